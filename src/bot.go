@@ -2,14 +2,19 @@ package main
 
 import (
     "./crucible"
+    "./mattermost"
+    "./notify"
     "./slack"
+    "context"
     "encoding/json"
+    "flag"
     "fmt"
-    "io/ioutil"
     "log"
-    "reflect"
+    "os"
+    "os/signal"
     "strings"
     "sync"
+    "syscall"
     "time"
     "golang.org/x/net/websocket"
     "strconv"
@@ -17,204 +22,503 @@ import (
 
 // Документация https://docs.atlassian.com/fisheye-crucible/latest/wadl/crucible.html
 
-var CONFIG Config
+// Сколько ждать отправки оставшихся сообщений при остановке
+const shutdownTimeout = 10 * time.Second
+
+var CONFIG = &ConfigHolder{}
 
 type Config struct {
     Crucible crucible.Config   `json:"crucible"`
     Slack    slack.Config      `json:"slack"`
-    UserMap  map[string]string `json:"userMap"`
-    ProjectMap map[string]string `json:"projectMap"`
+    UserMap  map[string]UserConfig `json:"userMap"`
+    ProjectMap map[string]ProjectConfig `json:"projectMap"`
+    Reminders ReminderConfig `json:"reminders"`
+    Digests []DigestConfig `json:"digests"`
+    History HistoryConfig `json:"history"`
+    Stats StatsConfig `json:"stats"`
+    HTTP HTTPConfig `json:"http"`
+    // Правила маршрутизации уведомлений о ревью
+    Routes []RouteRule `json:"routes"`
+    // Получатели уведомлений по имени, кроме каналов Slack
+    Notifiers map[string]NotifierConfig `json:"notifiers"`
+    // Почтовый сервер для уведомлений по почте
+    SMTP notify.SMTPConfig `json:"smtp"`
+    // Mattermost для уведомлений и команд, необязательно
+    Mattermost mattermost.Config `json:"mattermost"`
+    // Письма пользователям с их ревью
+    MailDigest MailDigestConfig `json:"mailDigest"`
+    // Исходящие webhook для своей автоматизации
+    Hooks HooksConfig `json:"hooks"`
+    // Вызовы из Crucible о событиях ревью
+    Triggers TriggerConfig `json:"triggers"`
+    // Частота опроса проектов
+    Polling PollingConfig `json:"polling"`
+}
+
+/*
+    Пользователь Crucible: ник в Slack и почта для писем с ревью
+ */
+type UserConfig struct {
+    Slack string `json:"slack"`
+    // Имя в Mattermost, если не указано — как в Slack
+    Mattermost string `json:"mattermost"`
+    Email string `json:"email"`
+}
+
+/*
+    Ник пользователя в чате: slack или mattermost
+ */
+func (user *UserConfig) ChatNick(kind string) string {
+    if kind == NotifierMattermost && user.Mattermost != "" {
+        return user.Mattermost
+    }
+
+    return user.Slack
+}
+
+func (user *UserConfig) UnmarshalJSON(data []byte) error {
+    var nick string
+
+    // Старый формат: имя в Crucible -> ник в Slack
+    if json.Unmarshal(data, &nick) == nil {
+        *user = UserConfig{Slack: nick}
+        return nil
+    }
+
+    type plain UserConfig
+    return json.Unmarshal(data, (*plain)(user))
 }
 
 func (config *Config) ChannelName(projectName string) (channel string, ok bool) {
-    channel, ok = config.ProjectMap[projectName];
-    return
+    project, ok := config.ProjectMap[projectName]
+    return project.Channel, ok && project.Channel != ""
 }
 
-func getConfig() (config Config, err error) {
-    data, err := ioutil.ReadFile("config.json")
-    if err != nil {
-        return
+/*
+    Канал проекта в чате `kind`: в Slack — `channel`, в Mattermost — `mattermostChannel`
+    или служебный канал Mattermost
+ */
+func (config *Config) ChatChannelName(kind string, projectName string) (channel string, ok bool) {
+    if kind != NotifierMattermost {
+        return config.ChannelName(projectName)
     }
 
-    err = json.Unmarshal(data, &config)
-    if err != nil {
-        return
+    project, ok := config.ProjectMap[projectName]
+    channel = project.GetMattermostChannel()
+
+    return channel, ok && channel != ""
+}
+
+/*
+    Канал проекта, если не указан — служебный канал
+ */
+func projectChannel(projectName string) string {
+    channelName, ok := CONFIG.Get().ChannelName(projectName)
+
+    if ok == false {
+        log.Println("Не указан канал для проекта", projectName)
+        channelName = CONFIG.Get().Slack.ChannelName()
     }
 
-    return
+    if channelName == "" {
+        log.Println("Не указан служебный канал", projectName)
+    }
+
+    return channelName
 }
 
 func MapUserNicks(names []string) string {
+    return mapChatNicks(NotifierSlack, names, true)
+}
+
+/*
+    Ники пользователей в чате `kind` через запятую, с `mention` — с @ для упоминания
+ */
+func mapChatNicks(kind string, names []string, mention bool) string {
 
     mentions := []string{}
 
     for _, value := range names {
-        nick := CONFIG.UserMap[value]
+        user := CONFIG.Get().UserMap[value]
+        nick := user.ChatNick(kind)
         if nick == "" {
             nick = value
         }
-        mentions = append(mentions, fmt.Sprintf("@%s", nick))
+        if mention {
+            nick = "@" + nick
+        }
+        mentions = append(mentions, nick)
     }
 
     return strings.Join(mentions, ", ")
 }
 
+/*
+    Длительность в виде "2 д 3 ч", "5 ч 10 мин", "12 мин"
+ */
+func formatDuration(duration time.Duration) string {
+    minutes := int(duration.Minutes())
+    days, hours := minutes/(24*60), minutes/60%24
+    minutes = minutes % 60
+
+    switch {
+    case days > 0:
+        return fmt.Sprintf("%d д %d ч", days, hours)
+    case hours > 0:
+        return fmt.Sprintf("%d ч %d мин", hours, minutes)
+    default:
+        return fmt.Sprintf("%d мин", minutes)
+    }
+}
+
+/*
+    Описание хода ревью: возраст и кто из ревьюверов завершил и за сколько, ники — чата `kind`
+ */
+func reviewProgress(kind string, review crucible.Review) string {
+    lines := []string{}
+
+    if age := review.Age(); age > 0 {
+        lines = append(lines, fmt.Sprintf("Открыто %s назад", formatDuration(age)))
+    }
+
+    completed := []string{}
+
+    for _, name := range review.GetReviewersNames() {
+        if latency, ok := review.ReviewerLatency(name); ok {
+            completed = append(completed, fmt.Sprintf("%s (%s)", mapChatNicks(kind, []string{name}, true), formatDuration(latency)))
+        }
+    }
+
+    if len(completed) > 0 {
+        lines = append(lines, "Завершили: "+strings.Join(completed, ", "))
+    }
+
+    if pending := review.GetPendingReviewersNames(); len(pending) > 0 {
+        lines = append(lines, "Ждём: "+mapChatNicks(kind, pending, true))
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+/*
+    Имя пользователя в Crucible по нику в Slack (обратное отображение `UserMap`)
+ */
+func crucibleUserName(kind string, nick string) string {
+    for crucibleName, user := range CONFIG.Get().UserMap {
+        if user.ChatNick(kind) == nick {
+            return crucibleName
+        }
+    }
+
+    return nick
+}
+
 type ReviewEvent struct {
     ProjectName string
     OldRev crucible.Review
     NewRev crucible.Review
+    // Почему ревью пропало из проекта, см. RemovalDeleted; пусто — обычное изменение
+    Removed string
 }
 
 func main() {
-    reviewEvents := make(chan ReviewEvent)
+    flag.StringVar(&configPath, "config", configPathFromEnv(), "файл конфига, json или yaml")
+    printConfigOnly := flag.Bool("print-config", false, "вывести итоговый конфиг с настройками проектов по умолчанию, без секретов, и выйти")
+    flag.Parse()
+
+    if *printConfigOnly {
+        config, err := getConfig(configPath)
+
+        // Конфиг с ошибками проверки всё равно выводится
+        if _, invalid := err.(ConfigErrors); err != nil && !invalid {
+            log.Fatalln("Ошибка чтения конфига", err)
+        }
+
+        printConfig(os.Stdout, config)
+
+        if err != nil {
+            log.Fatalln("Ошибка в конфиге", configPath, err)
+        }
+
+        return
+    }
+
+    // Подкоманды
+    if args := flag.Args(); len(args) > 0 {
+        switch args[0] {
+        case "export":
+            err := runExport(args[1:])
+
+            if err != nil {
+                log.Fatalln("Ошибка выгрузки", err)
+            }
+
+            return
+        case "validate":
+            err := runValidate(args[1:])
+
+            if err != nil {
+                log.Fatalln("Ошибка в конфиге", configPath, err)
+            }
+
+            return
+        }
+    }
+
+    // Остановка по SIGINT/SIGTERM
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    reviewEvents := make(chan ReviewEvent, 100)
+    commentEvents := make(chan CommentEvent, 100)
+
+    health.AddQueue("reviewEvents", func() int { return len(reviewEvents) })
+    health.AddQueue("commentEvents", func() int { return len(commentEvents) })
 
     log.Println("Старт бота")
     log.Println("Чтение конфига...")
-    var err error
-    CONFIG, err = getConfig()
+    config, err := getConfig(configPath)
 
     if err != nil {
-        log.Fatalln("Ошибка чтения конфига", err)
+        log.Fatalln("Ошибка в конфиге", configPath, err)
         return
     }
 
-    if len(CONFIG.ProjectMap) == 0 {
-        log.Fatalln("Надо указать хотябы один проект")
-    }
+    CONFIG.Set(config)
 
     log.Println("Конфиг получен")
 
-    slackClient, err := slack.CreateClient(CONFIG.Slack)
+    slackClient, err := slack.CreateClient(CONFIG.Get().Slack)
 
     if err != nil {
         log.Fatalln("Ошибка создания Slack клиента", err)
         return
     }
 
-    err = slackClient.TestAuth()
+    err = slackClient.TestAuth(ctx)
+    health.SetComponent("slack", err)
 
     if err != nil {
         log.Fatalln("Не удалось авторизаваться с Slack", err)
     }
 
-    crucibleClient, err := crucible.CreateClient(CONFIG.Crucible)
+    rtmStart, err := slackClient.RTMStart(ctx)
+
+    if err == nil && rtmStart.Ok {
+        if errs := validateSlackChannels(config, rtmStart); len(errs) > 0 {
+            log.Fatalln("Ошибка в конфиге", configPath, errs)
+        }
+    } else {
+        log.Println("Не удалось проверить каналы в Slack", err)
+    }
+
+    if config.Mattermost.Enabled() {
+        mattermostClient, err = mattermost.CreateClient(config.Mattermost)
+
+        if err != nil {
+            log.Fatalln("Ошибка создания Mattermost клиента", err)
+        }
+
+        _, err = mattermostClient.TestAuth(ctx)
+        health.SetComponent("mattermost", err)
+
+        if err != nil {
+            log.Fatalln("Не удалось авторизоваться в Mattermost", err)
+        }
+    }
+
+    crucibleClient, err := crucible.CreateClient(CONFIG.Get().Crucible)
 
     if err != nil {
         log.Fatalln("Не удалось создать Crucible клиент", err)
     }
 
-    _, err = crucibleClient.GetToken()
+    _, err = crucibleClient.GetToken(ctx)
+    health.SetComponent("crucible", err)
 
     if err != nil {
         log.Fatalln("Не удалось авторизоваться в Crucible", err)
     }
 
-    var wg sync.WaitGroup
+    reviewHistory, err = LoadReviewHistory(CONFIG.Get().History)
+
+    if err != nil {
+        log.Println("Ошибка чтения истории ревью", CONFIG.Get().History.File, err)
+    }
+
+    // Отправка в Slack продолжается после сигнала остановки, пока не разобраны очереди,
+    // но не дольше shutdownTimeout
+    sendCtx, cancelSend := context.WithCancel(context.Background())
+    defer cancelSend()
+
+    // Исходящие webhook, у каждого своя очередь с повторами
+    hookDispatcher = NewHookDispatcher(sendCtx, CONFIG.Get().Hooks)
+
+    var servers sync.WaitGroup
+
+    if CONFIG.Get().HTTP.Listen != "" {
+        servers.Add(1)
+        go serveHTTP(ctx, CONFIG.Get().HTTP, &servers)
+    }
 
-    wg.Add(1)
-    go watchCommand(&slackClient, &crucibleClient, &wg)
+    // Горутины, которые опрашивают Crucible и Slack и порождают события
+    var producers sync.WaitGroup
+    // Горутины, которые рассылают события в Slack
+    var consumers sync.WaitGroup
+
+    producers.Add(1)
+    go watchCommand(ctx, &slackClient, &crucibleClient, &producers)
+
+    if mattermostClient != nil {
+        producers.Add(1)
+        go watchMattermostCommand(ctx, mattermostClient, &crucibleClient, &producers)
+    }
 
 
     // Рассылка сообщений в Slack
-    go listenReviewUpdate(reviewEvents, slackClient)
+    consumers.Add(1)
+    go listenReviewUpdate(sendCtx, reviewEvents, slackClient, &consumers)
+
+    // Рассылка комментариев в треды ревью
+    consumers.Add(1)
+    go listenCommentUpdate(sendCtx, commentEvents, slackClient, &consumers)
 
 
-    for projectName, _ := range CONFIG.ProjectMap {
-        log.Println("Подключаем проект", projectName)
-        wg.Add(1)
-        go watchProject(projectName, &crucibleClient, reviewEvents, &wg)
+    // Напоминания о зависших ревью
+    if CONFIG.Get().RemindersEnabled() {
+        producers.Add(1)
+        go watchReminders(ctx, &crucibleClient, slackClient, CONFIG.Get().Reminders, &producers)
     }
 
-    wg.Wait()
-}
+    // Дайджесты открытых ревью по расписанию
+    for _, digest := range CONFIG.Get().Digests {
+        producers.Add(1)
+        go watchDigest(ctx, &crucibleClient, slackClient, digest, &producers)
+    }
 
-/*
-    Слежение за списком ревью, при обновлении ревью посылает событие в канал `eventChannel chan ReviewEvent`
- */
-func watchProject(projectName string, crucibleClient *crucible.Crucible, eventChannel chan ReviewEvent, wg *sync.WaitGroup) {
-    timeout := CONFIG.Crucible.Timeout
-    reviews, err := crucibleClient.GetReviews(crucible.GetReviewsOptions{
-        Project: projectName,
-        FromDate: time.Now().AddDate(0, 0, -7), // weekago
-    })
+    // Еженедельный отчёт со статистикой
+    if CONFIG.Get().Stats.Schedule != "" {
+        producers.Add(1)
+        go watchStats(ctx, &crucibleClient, slackClient, CONFIG.Get().Stats, &producers)
+    }
 
+    if CONFIG.Get().MailDigest.Enabled() {
+        producers.Add(1)
+        go watchMailDigests(ctx, &crucibleClient, CONFIG.Get().MailDigest, &producers)
+    }
 
+    // Общий опрос проектов, список проектов меняется при перечитывании конфига
+    supervisor := NewProjectSupervisor(ctx, &crucibleClient, reviewEvents, commentEvents, &producers)
+    supervisor.Sync(config.ProjectMap)
 
-    if err != nil {
-        log.Fatalln("Ошибка получения списка review", err)
-        return
+    producers.Add(1)
+    go watchConfig(ctx, configPath, supervisor, &producers)
+
+    if CONFIG.Get().Triggers.Enabled() {
+        producers.Add(1)
+        go watchTriggers(ctx, &crucibleClient, supervisor, &producers)
     }
 
-    log.Println("Получили список ревью", projectName, len(reviews.Reviews))
-    count := 0
-    updateError := false
+    <-ctx.Done()
+    log.Println("Остановка бота...")
+    time.AfterFunc(shutdownTimeout, cancelSend)
 
-    for {
-        time.Sleep(timeout * time.Second)
-        update, err := crucibleClient.GetReviews(crucible.GetReviewsOptions{
-            Project: projectName,
-            FromDate: time.Now().AddDate(0, 0, -7), // weekago
-        })
+    producers.Wait()
 
-        if err != nil {
-            if updateError == false {
-                log.Println("Ошибка обновления списка review", projectName, err)
-                updateError = true
-            }
-            continue
-        } else if updateError {
-            log.Println("Успешно обновлён список review после ошибки", projectName)
-            updateError = false
-        }
+    // Дожидаемся отправки событий, оставшихся в очередях
+    close(reviewEvents)
+    close(commentEvents)
+    consumers.Wait()
+    hookDispatcher.Close()
 
-        if len(update.Reviews) == 0 {
-            // Пустой список ревью
-            log.Println("Пришел пустой список ревью", projectName)
-            continue
-        }
+    if err := reviewHistory.Save(); err != nil {
+        log.Println("Ошибка сохранения истории ревью", err)
+    }
 
-        for _, renewed := range update.Reviews {
-            old, _ := reviews.FindById(renewed.GetID())
+    servers.Wait()
+    log.Println("Бот остановлен")
+}
 
-            // Новое ревью или обновилось
-            if !reflect.DeepEqual(renewed, old) {
-                eventChannel <- ReviewEvent{
-                    ProjectName: projectName,
-                    NewRev: renewed,
-                    OldRev: old,
-                }
-            }
-        }
+/*
+    Пауза, прерываемая остановкой бота. Возвращает false, если бот останавливается
+ */
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+    timer := time.NewTimer(duration)
+    defer timer.Stop()
+
+    select {
+    case <-ctx.Done():
+        return false
+    case <-timer.C:
+        return true
+    }
+}
 
-        reviews = update
-        count++
+/*
+    Событие о новом или изменившемся ревью
+ */
+func emitReviewUpdate(projectName string, old crucible.Review, renewed crucible.Review, eventChannel chan ReviewEvent) {
+    if old.GetID() == "" {
+        eventsTotal.Inc("review.new")
+    } else {
+        eventsTotal.Inc("review.update")
     }
 
-    wg.Done()
+    eventChannel <- ReviewEvent{
+        ProjectName: projectName,
+        NewRev: renewed,
+        OldRev: old,
+    }
 }
 
-func listenReviewUpdate(reviewEvents chan ReviewEvent, slackClient slack.SlackClient){
+/*
+    Рассылка уведомлений об изменении ревью, работает пока канал `reviewEvents` не закрыт
+ */
+func listenReviewUpdate(ctx context.Context, reviewEvents chan ReviewEvent, slackClient slack.SlackClient, wg *sync.WaitGroup){
+    defer wg.Done()
 
-    for {
-        event := <-reviewEvents
+    for event := range reviewEvents {
+        if event.Removed != "" {
+            notifyRemoved(ctx, slackClient, event)
+            continue
+        }
 
         n := event.NewRev
         o := event.OldRev
+        project := CONFIG.Get().Project(event.ProjectName)
         mTemplate := ""
-        author := MapUserNicks([]string{event.NewRev.GetAuthorNick()})
-        reviewers := MapUserNicks(event.NewRev.GetReviewersNames())
+        author := project.MapUserNicks([]string{event.NewRev.GetAuthorNick()})
+        reviewers := project.MapUserNicks(event.NewRev.GetReviewersNames())
 
         equal, diff := crucible.Compare(o, n)
 
         log.Println("compare", equal, diff)
 
+        if n.IsUnexpectedTransition(o.GetState()) {
+            notificationsTotal.Inc("unexpected_transition")
+            reportUnexpectedTransition(ctx, slackClient, event)
+        }
+
+        notification := ""
+
         if n.IsOpen() && !o.IsOpen() {
-            mTemplate = "%[2]s нужно ревью"
+            notification = EventOpened
+        }
+
+        if project.IsCompleted(n) && !project.IsCompleted(o) {
+            notification = EventCompleted
+        }
+
+        hookDispatcher.Emit(event, hookEventType(event, notification), diff)
+
+        if notification != "" && project.EventEnabled(notification) {
+            mTemplate = project.Template(notification)
+            notificationsTotal.Inc(notification)
         }
 
-        if n.IsCompleted() && !o.IsCompleted() {
-            mTemplate = "%[1]s ревью завершен"
+        title := n.Name
+        if title == "" {
+            title = n.GetID()
         }
 
 
@@ -229,49 +533,112 @@ template %s`,
             event.NewRev.GetID(),
             event.NewRev.Name,
             author,
-            event.NewRev.GetURL(CONFIG.Crucible.Host),
+            event.NewRev.GetURL(CONFIG.Get().Crucible.Host),
             event.OldRev.GetState(), event.NewRev.GetState(),
-            fmt.Sprintf(mTemplate, author, reviewers),
+            renderTemplate(mTemplate, author, reviewers, title),
         )
 
         if mTemplate == "" {
             continue
         }
 
-        channelName, ok := CONFIG.ChannelName(event.ProjectName)
+        mattermostText, mattermostAuthor := project.RenderChatTemplate(NotifierMattermost, mTemplate, n, title)
 
-        if ok == false {
-            log.Println("Не указан канал для проекта", event.ProjectName)
-            channelName = CONFIG.Slack.ChannelName()
-        }
+        posted, ok := deliver(ctx, routeEvent(slackClient, event.ProjectName, notification, n), notify.Notification{
+            Event: notification,
+            Project: event.ProjectName,
+            Review: n,
+            Text: renderTemplate(mTemplate, author, reviewers, title),
+            Title: title,
+            URL: n.GetURL(CONFIG.Get().Crucible.Host),
+            AuthorName: author,
+            MattermostText: mattermostText,
+            MattermostAuthorName: mattermostAuthor,
+        })
 
-        if channelName == "" {
-            log.Println("Не указан служебный канал", event.ProjectName)
+        if !ok {
+            continue
         }
 
-        slackMessage := slack.Message{
-            Text: fmt.Sprintf(mTemplate, author, reviewers),
-            Channel: channelName,
-            IconUrl: "http://lorempixel.com/48/48/cats/",
-            AsUser: false,
+        // Комментарии к ревью пойдут в тред первого сообщения в канал
+        if _, ok := reviewThreads.Get(n.GetID()); !ok || n.IsOpen() && !o.IsOpen() {
+            reviewThreads.Set(n.GetID(), ReviewThread{Channel: posted.Channel, Ts: posted.Ts})
         }
+    }
+}
 
-        title := n.Name
-        if title == "" {
-            title = n.GetID()
-        }
+/*
+    Событие webhook и, если проект подписан на removed, уведомление о пропавшем ревью
+ */
+func notifyRemoved(ctx context.Context, slackClient slack.SlackClient, event ReviewEvent) {
+    review := event.NewRev
+    project := CONFIG.Get().Project(event.ProjectName)
 
-        slackMessage.AddAttachment(slack.Attachment{
-            AuthorName: author,
-            Title:      title,
-            TitleLink:  event.NewRev.GetURL(CONFIG.Crucible.Host),
-        })
+    hookDispatcher.Emit(event, HookReviewRemoved, nil)
+
+    if !project.EventEnabled(EventRemoved) {
+        return
+    }
 
-        err := slackClient.PostMessage(slackMessage)
+    title := review.Name
+    if title == "" {
+        title = review.GetID()
+    }
 
-        if err != nil {
-            log.Println("Ошибка отправки сообщения", err)
-        }
+    text, author := project.RenderChatTemplate(NotifierSlack, project.Template(EventRemoved), review, title)
+    mattermostText, mattermostAuthor := project.RenderChatTemplate(NotifierMattermost, project.Template(EventRemoved), review, title)
+
+    reason := "удалено"
+
+    switch event.Removed {
+    case RemovalMoved:
+        reason = "перенесено в проект " + review.ProjectKey
+    case RemovalForbidden:
+        reason = "у бота нет доступа"
+    }
+
+    notificationsTotal.Inc(EventRemoved)
+
+    deliver(ctx, routeEvent(slackClient, event.ProjectName, EventRemoved, review), notify.Notification{
+        Event: EventRemoved,
+        Project: event.ProjectName,
+        Review: review,
+        Text: fmt.Sprintf("%s: %s", text, reason),
+        Title: title,
+        URL: review.GetURL(CONFIG.Get().Crucible.Host),
+        AuthorName: author,
+        MattermostText: fmt.Sprintf("%s: %s", mattermostText, reason),
+        MattermostAuthorName: mattermostAuthor,
+        Color: "warning",
+    })
+}
+
+/*
+    Сообщение в служебный канал о переходе ревью, которого не бывает в Crucible
+ */
+func reportUnexpectedTransition(ctx context.Context, slackClient slack.SlackClient, event ReviewEvent) {
+    text := fmt.Sprintf(
+        "Неожиданный переход ревью %s: %s -> %s",
+        event.NewRev.GetID(), event.OldRev.GetState(), event.NewRev.GetState(),
+    )
+
+    log.Println(text, event.ProjectName)
+
+    channelName := CONFIG.Get().Slack.ChannelName()
+
+    if channelName == "" {
+        return
+    }
+
+    _, err := slackClient.PostMessage(ctx, slack.Message{
+        Text: text,
+        Channel: channelName,
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    })
+
+    if err != nil {
+        log.Println("Ошибка отправки сообщения", err)
     }
 }
 
@@ -308,39 +675,102 @@ func (m *SlackMessage) GetTime() time.Time {
 /**
     Обрабатывае сообщения из слака и преобразует в команды
  */
-func watchCommand(slackClient *slack.SlackClient, crucibleClient *crucible.Crucible, wg *sync.WaitGroup) {
+func watchCommand(ctx context.Context, slackClient *slack.SlackClient, crucibleClient *crucible.Crucible, wg *sync.WaitGroup) {
+    defer wg.Done()
+
     var ws *websocket.Conn
     var rtmStart slack.RTMStart
     var err error
 
+    // Закрытие соединения прерывает ожидание сообщения при остановке бота
+    closed := make(chan struct{})
+    defer close(closed)
+
+    var wsMutex sync.Mutex
+    go func() {
+        select {
+        case <-ctx.Done():
+            wsMutex.Lock()
+            if ws != nil {
+                ws.Close()
+            }
+            wsMutex.Unlock()
+        case <-closed:
+        }
+    }()
+
+    // Было ли соединение, новое соединение после него — переподключение
+    connected := false
+
     for {
+        if ctx.Err() != nil {
+            return
+        }
 
         if ws == nil || ws.IsClientConn() == false {
-            rtmStart, err = slackClient.RTMStart()
+            rtmStart, err = slackClient.RTMStart(ctx)
 
             if err != nil {
-                log.Fatalln("Ошибка получения настроек для RTM", err)
-                return
+                log.Println("Ошибка получения настроек для RTM", err)
+                health.SetComponent("slack_rtm", err)
+
+                if !sleepContext(ctx, 5 * time.Second) {
+                    return
+                }
+                continue
             }
 
-            ws, err = websocket.Dial(rtmStart.Url, "", "http://localhost/")
+            conn, err := websocket.Dial(rtmStart.Url, "", "http://localhost/")
+            health.SetComponent("slack_rtm", err)
 
             if err != nil {
                 log.Println("Ошибка websocket соединения", err)
+
+                if !sleepContext(ctx, 5 * time.Second) {
+                    return
+                }
                 continue
             } else {
                 log.Println("Готов принимать команды через Slack")
             }
+
+            if connected {
+                websocketReconnectsTotal.Inc()
+            }
+            connected = true
+
+            wsMutex.Lock()
+            ws = conn
+            wsMutex.Unlock()
+
+            // Соединение могло быть создано уже после сигнала остановки
+            if ctx.Err() != nil {
+                ws.Close()
+                return
+            }
         }
 
         var messageRaw []byte
         var message SlackMessage
         err := websocket.Message.Receive(ws, &messageRaw)
         if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+
             log.Println("Ошибка получения сообщения из Slack", err)
+            health.SetComponent("slack_rtm", err)
+
+            // Переподключаемся
+            wsMutex.Lock()
+            ws.Close()
+            ws = nil
+            wsMutex.Unlock()
             continue
         }
 
+        health.SetComponent("slack_rtm", nil)
+
         err = json.Unmarshal(messageRaw, &message)
         if err != nil {
             log.Println("Ошибка парсинга в JSON", err)
@@ -353,72 +783,6 @@ func watchCommand(slackClient *slack.SlackClient, crucibleClient *crucible.Cruci
 
         //log.Println("Сообщение", string(messageRaw[:]))
 
-        if strings.Contains(message.Text, "review list") {
-            since := time.Since(message.Time)
-            if since.Seconds() > 10 {
-                continue
-            }
-
-            log.Println("Выполняем команду...", "Message since", since.Seconds())
-            slackClient.PostMessage(slack.Message{
-                Channel: message.ChannelID,
-                Text: "Минутку...",
-            });
-
-            reviews, err := crucibleClient.GetReviews(crucible.GetReviewsOptions{
-                States: []string{"Review"},
-            });
-
-            if err != nil {
-                log.Println("Ошибка получения ревью:", err)
-                continue
-            }
-
-            messageList := slack.Message{
-                Text: "Список незакрытых ревью",
-                Channel: message.ChannelID,
-                IconUrl: "http://lorempixel.com/48/48/cats/",
-                AsUser: false,
-            }
-
-            // Сформировать сообщение со списком открытых ревью
-            for _, rev := range reviews.Reviews {
-                attachment := slack.Attachment{
-                    TitleLink: rev.GetURL(CONFIG.Crucible.Host),
-                    AuthorName: MapUserNicks([]string{rev.GetAuthorNick()}),
-                }
-
-                attachment.Title = rev.Name;
-                if attachment.Title == "" {
-                    attachment.Title = rev.GetID()
-                }
-
-                attachment.Color = "good"
-
-                if !rev.IsCompleted() {
-                    attachment.Color = "danger" // red
-                }
-
-                projectChannelName, ok := CONFIG.ChannelName(rev.ProjectKey)
-
-                if ok == false {
-                    log.Println("Не найден канал для проекта", rev.ProjectKey);
-                }
-
-                if projectChannelName == message.ChannelName ||
-                    CONFIG.Slack.ChannelName() == message.ChannelName {
-                    messageList.AddAttachment(attachment)
-                }
-            }
-
-            if len(messageList.Attachments) == 0 {
-                messageList.Text = "Все ревью закрыты"
-            }
-
-            slackClient.PostMessage(messageList)
-            log.Println("Отправили список...")
-        }
-
+        dispatchCommand(ctx, &slackChat{slackClient, rtmStart}, crucibleClient, message)
     }
-    wg.Done()
 }
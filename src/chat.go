@@ -0,0 +1,207 @@
+package main
+
+import (
+    "./crucible"
+    "./mattermost"
+    "./slack"
+    "context"
+    "encoding/json"
+    "log"
+    "strings"
+    "sync"
+    "time"
+    "golang.org/x/net/websocket"
+)
+
+// Клиент Mattermost, если он настроен
+var mattermostClient *mattermost.Client
+
+/*
+    Отправка сообщений в формате Slack: в Slack или Mattermost
+ */
+type MessagePoster interface {
+    PostMessage(ctx context.Context, message slack.Message) (slack.PostMessageResponse, error)
+}
+
+/*
+    Чат, из которого пришла команда
+ */
+type Chat interface {
+    MessagePoster
+    // Имя пользователя по id из сообщения
+    UserName(ctx context.Context, id string) string
+    // Служебный канал, в нём команды видят ревью всех проектов
+    ServiceChannel() string
+    // slack или mattermost, по нему ники сопоставляются с userMap
+    Kind() string
+}
+
+type slackChat struct {
+    *slack.SlackClient
+    rtmStart slack.RTMStart
+}
+
+func (chat *slackChat) UserName(ctx context.Context, id string) string {
+    return chat.rtmStart.UserName(id)
+}
+
+func (chat *slackChat) ServiceChannel() string {
+    return CONFIG.Get().Slack.ChannelName()
+}
+
+func (chat *slackChat) Kind() string {
+    return NotifierSlack
+}
+
+type mattermostChat struct {
+    *mattermost.Client
+}
+
+func (chat *mattermostChat) ServiceChannel() string {
+    return CONFIG.Get().Mattermost.Channel
+}
+
+func (chat *mattermostChat) Kind() string {
+    return NotifierMattermost
+}
+
+/*
+    Запуск команды из сообщения, одинаково для Slack и Mattermost
+ */
+func dispatchCommand(ctx context.Context, chat Chat, crucibleClient *crucible.Crucible, message SlackMessage) {
+    var command func(context.Context, Chat, *crucible.Crucible, SlackMessage)
+
+    switch {
+    case strings.Contains(message.Text, "review create"):
+        command = commandReviewCreate
+    case strings.Contains(message.Text, "review stats"):
+        command = commandReviewStats
+    case strings.Contains(message.Text, "review list"):
+        command = commandReviewList
+    default:
+        return
+    }
+
+    since := time.Since(message.Time)
+    if since.Seconds() > 10 {
+        return
+    }
+
+    log.Println("Выполняем команду...", "Message since", since.Seconds())
+    command(ctx, chat, crucibleClient, message)
+}
+
+/*
+    Получение команд из Mattermost через websocket
+ */
+func watchMattermostCommand(ctx context.Context, client *mattermost.Client, crucibleClient *crucible.Crucible, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    chat := &mattermostChat{client}
+
+    var ws *websocket.Conn
+
+    // Закрытие соединения прерывает ожидание сообщения при остановке бота
+    closed := make(chan struct{})
+    defer close(closed)
+
+    var wsMutex sync.Mutex
+    go func() {
+        select {
+        case <-ctx.Done():
+            wsMutex.Lock()
+            if ws != nil {
+                ws.Close()
+            }
+            wsMutex.Unlock()
+        case <-closed:
+        }
+    }()
+
+    // Было ли соединение, новое соединение после него — переподключение
+    connected := false
+
+    for {
+        if ctx.Err() != nil {
+            return
+        }
+
+        if ws == nil {
+            conn, err := client.Dial()
+            health.SetComponent("mattermost_ws", err)
+
+            if err != nil {
+                log.Println("Ошибка websocket соединения с Mattermost", err)
+
+                if !sleepContext(ctx, 5 * time.Second) {
+                    return
+                }
+                continue
+            }
+
+            log.Println("Готов принимать команды через Mattermost")
+
+            if connected {
+                websocketReconnectsTotal.Inc()
+            }
+            connected = true
+
+            wsMutex.Lock()
+            ws = conn
+            wsMutex.Unlock()
+
+            if ctx.Err() != nil {
+                ws.Close()
+                return
+            }
+        }
+
+        var messageRaw []byte
+        err := websocket.Message.Receive(ws, &messageRaw)
+
+        if err != nil {
+            if ctx.Err() != nil {
+                return
+            }
+
+            log.Println("Ошибка получения сообщения из Mattermost", err)
+            health.SetComponent("mattermost_ws", err)
+
+            wsMutex.Lock()
+            ws.Close()
+            ws = nil
+            wsMutex.Unlock()
+            continue
+        }
+
+        var event mattermost.Event
+
+        if err := json.Unmarshal(messageRaw, &event); err != nil {
+            log.Println("Ошибка парсинга в JSON", err)
+            continue
+        }
+
+        post, channelName, ok := event.Posted()
+
+        // Свои сообщения не разбираем
+        if !ok || post.UserID == client.Me().ID {
+            continue
+        }
+
+        if channelName == "" {
+            channelName = client.ChannelName(ctx, post.ChannelID)
+        } else {
+            client.RememberChannel(mattermost.Channel{ID: post.ChannelID, Name: channelName})
+        }
+
+        dispatchCommand(ctx, chat, crucibleClient, SlackMessage{
+            Type: "message",
+            ChannelID: post.ChannelID,
+            ChannelName: channelName,
+            User: post.UserID,
+            Text: post.Message,
+            Ts: post.ID,
+            Time: post.GetTime(),
+        })
+    }
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+    "./crucible"
+    "./slack"
+    "context"
+    "fmt"
+    "html"
+    "log"
+    "regexp"
+    "strings"
+)
+
+const reviewCreateUsage = "Использование: `review create <проект> repo=<репозиторий> cs=<changeset>[,<changeset>] name=\"<название>\" " +
+    "[desc=\"<описание>\"] [jira=<KEY-1>] @ревьювер ...`, вместо `cs` можно указать `path=<путь> rev=<ревизия>[,<ревизия>]`"
+
+// Упоминание пользователя в тексте сообщения Slack: <@U024BE7LH> или <@U024BE7LH|name>
+var slackMentionRe = regexp.MustCompile(`^<@([A-Z0-9]+)(?:\|([^>]+))?>$`)
+
+/*
+    Разбивает текст команды на аргументы, значения в кавычках не разбиваются
+ */
+func parseCommandArgs(text string) (args []string) {
+    quotes := map[rune]rune{'"': '"', '“': '”', '«': '»', '\'': '\''}
+    current := []rune{}
+    var closing rune
+    inArg := false
+
+    for _, r := range text {
+        switch {
+        case closing != 0:
+            if r == closing {
+                closing = 0
+            } else {
+                current = append(current, r)
+            }
+        case quotes[r] != 0:
+            closing = quotes[r]
+            inArg = true
+        case r == ' ' || r == '\t' || r == '\n':
+            if inArg {
+                args = append(args, string(current))
+                current = current[:0]
+                inArg = false
+            }
+        default:
+            current = append(current, r)
+            inArg = true
+        }
+    }
+
+    if inArg {
+        args = append(args, string(current))
+    }
+
+    return
+}
+
+func splitList(value string) (list []string) {
+    for _, item := range strings.Split(value, ",") {
+        item = strings.TrimSpace(item)
+        if item != "" {
+            list = append(list, item)
+        }
+    }
+
+    return
+}
+
+/*
+    Ник в Slack по упоминанию из текста сообщения
+ */
+func mentionNick(ctx context.Context, arg string, chat Chat) (nick string, ok bool) {
+    if match := slackMentionRe.FindStringSubmatch(arg); match != nil {
+        nick = chat.UserName(ctx, match[1])
+        if nick == "" {
+            nick = match[2]
+        }
+        return nick, nick != ""
+    }
+
+    if strings.HasPrefix(arg, "@") && len(arg) > 1 {
+        return arg[1:], true
+    }
+
+    return
+}
+
+/**
+    review create: создаёт ревью в Crucible, добавляет ревьюверов и запускает его
+ */
+func commandReviewCreate(ctx context.Context, chat Chat, crucibleClient *crucible.Crucible, message SlackMessage) {
+    reply := func(text string) {
+        _, err := chat.PostMessage(ctx, slack.Message{
+            Channel: message.ChannelID,
+            Text: text,
+        })
+
+        if err != nil {
+            log.Println("Ошибка отправки сообщения", err)
+        }
+    }
+
+    text := message.Text[strings.Index(message.Text, "review create")+len("review create"):]
+
+    options := crucible.CreateReviewOptions{}
+    reviewers := []string{}
+
+    for _, arg := range parseCommandArgs(text) {
+        if nick, ok := mentionNick(ctx, arg, chat); ok {
+            reviewers = append(reviewers, crucibleUserName(chat.Kind(), nick))
+            continue
+        }
+
+        arg = html.UnescapeString(arg)
+        pair := strings.SplitN(arg, "=", 2)
+
+        if len(pair) == 1 {
+            if options.Project != "" {
+                reply(fmt.Sprintf("Непонятный аргумент `%s`\n%s", arg, reviewCreateUsage))
+                return
+            }
+            options.Project = arg
+            continue
+        }
+
+        switch key, value := pair[0], pair[1]; key {
+        case "repo":
+            options.Repository = value
+        case "cs":
+            options.Changesets = splitList(value)
+        case "path":
+            options.Path = value
+        case "rev":
+            options.Revisions = splitList(value)
+        case "name":
+            options.Name = value
+        case "desc":
+            options.Description = value
+        case "jira":
+            options.JiraIssueKey = value
+        default:
+            reply(fmt.Sprintf("Неизвестный параметр `%s`\n%s", key, reviewCreateUsage))
+            return
+        }
+    }
+
+    // Автором ревью становится написавший команду, если он есть в userMap
+    if author := crucibleUserName(chat.Kind(), chat.UserName(ctx, message.User)); author != "" {
+        if _, ok := CONFIG.Get().UserMap[author]; ok {
+            options.Author = author
+        }
+    }
+
+    review, err := crucibleClient.CreateReview(ctx, options)
+
+    if err != nil {
+        log.Println("Ошибка создания ревью:", err)
+        reply(fmt.Sprintf("Не удалось создать ревью: %s\n%s", err, reviewCreateUsage))
+        return
+    }
+
+    reviewURL := review.GetURL(CONFIG.Get().Crucible.Host)
+    log.Println("Создано ревью", review.GetID(), reviewURL)
+
+    err = crucibleClient.AddReviewers(ctx, review.GetID(), reviewers)
+
+    if err != nil {
+        log.Println("Ошибка добавления ревьюверов:", review.GetID(), err)
+        reply(fmt.Sprintf("Ревью %s создано, но не удалось добавить ревьюверов: %s", reviewURL, err))
+        return
+    }
+
+    _, err = crucibleClient.StartReview(ctx, review.GetID())
+
+    if err != nil {
+        log.Println("Ошибка запуска ревью:", review.GetID(), err)
+        reply(fmt.Sprintf("Ревью %s создано, но не удалось его запустить: %s", reviewURL, err))
+        return
+    }
+
+    reply(fmt.Sprintf("Ревью %s создано: %s", review.GetID(), reviewURL))
+}
+
+/*
+    Ревью в виде вложения Slack: ссылка, автор, ход ревью, цвет по завершённости.
+    Ники — чата `kind`: slack или mattermost
+ */
+func reviewAttachment(kind string, rev crucible.Review) slack.Attachment {
+    attachment := slack.Attachment{
+        TitleLink: rev.GetURL(CONFIG.Get().Crucible.Host),
+        AuthorName: mapChatNicks(kind, []string{rev.GetAuthorNick()}, true),
+        Text: reviewProgress(kind, rev),
+    }
+
+    attachment.Title = rev.Name;
+    if attachment.Title == "" {
+        attachment.Title = rev.GetID()
+    }
+
+    attachment.Color = "good"
+
+    project := CONFIG.Get().Project(rev.ProjectKey)
+
+    if !project.IsCompleted(rev) {
+        attachment.Color = "danger" // red
+    }
+
+    return attachment
+}
+
+/**
+    review list: список незакрытых ревью проекта канала
+ */
+func commandReviewList(ctx context.Context, chat Chat, crucibleClient *crucible.Crucible, message SlackMessage) {
+    chat.PostMessage(ctx, slack.Message{
+        Channel: message.ChannelID,
+        Text: "Минутку...",
+    });
+
+    reviews, err := crucibleClient.GetReviews(ctx, crucible.GetReviewsOptions{
+        States: []crucible.State{crucible.StateReview},
+    });
+
+    if err != nil {
+        log.Println("Ошибка получения ревью:", err)
+        return
+    }
+
+    messageList := slack.Message{
+        Text: "Список незакрытых ревью",
+        Channel: message.ChannelID,
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    // Сформировать сообщение со списком открытых ревью
+    for _, rev := range reviews.Reviews {
+        attachment := reviewAttachment(chat.Kind(), rev)
+
+        projectChannelName, ok := CONFIG.Get().ChatChannelName(chat.Kind(), rev.ProjectKey)
+
+        if ok == false {
+            log.Println("Не найден канал для проекта", rev.ProjectKey);
+        }
+
+        if projectChannelName == message.ChannelName ||
+            chat.ServiceChannel() == message.ChannelName {
+            messageList.AddAttachment(attachment)
+        }
+    }
+
+    if len(messageList.Attachments) == 0 {
+        messageList.Text = "Все ревью закрыты"
+    }
+
+    chat.PostMessage(ctx, messageList)
+    log.Println("Отправили список...")
+}
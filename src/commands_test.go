@@ -0,0 +1,166 @@
+package main
+
+import (
+    "./crucible"
+    "./mattermost"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+/*
+    Заглушка Crucible: токен и список ревью
+ */
+func newFakeCrucible(t *testing.T, reviews string) *crucible.Crucible {
+    server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+        switch request.URL.Path {
+        case "/rest-service-fecru/auth/login":
+            writer.Write([]byte(`{"token": "token"}`))
+        case "/rest-service/reviews-v1/filter/details":
+            writer.Write([]byte(`{"detailedReviewData": ` + reviews + `}`))
+        default:
+            http.NotFound(writer, request)
+        }
+    }))
+    t.Cleanup(server.Close)
+
+    client, err := crucible.CreateClient(crucible.Config{Host: server.URL})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return &client
+}
+
+/*
+    Заглушка Mattermost API: сохраняет отправленные сообщения
+ */
+type fakeMattermost struct {
+    mutex sync.Mutex
+    posts []mattermost.Post
+}
+
+func (fake *fakeMattermost) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+    fake.mutex.Lock()
+    defer fake.mutex.Unlock()
+
+    if request.Method != "POST" || request.URL.Path != "/api/v4/posts" {
+        http.NotFound(writer, request)
+        return
+    }
+
+    var post mattermost.Post
+    json.NewDecoder(request.Body).Decode(&post)
+
+    fake.posts = append(fake.posts, post)
+    json.NewEncoder(writer).Encode(post)
+}
+
+func newFakeMattermostChat(t *testing.T) (*mattermostChat, *fakeMattermost) {
+    fake := &fakeMattermost{}
+    server := httptest.NewServer(fake)
+    t.Cleanup(server.Close)
+
+    client, err := mattermost.CreateClient(mattermost.Config{Host: server.URL, Token: "token", Team: "dev"})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    client.RememberChannel(mattermost.Channel{ID: "c-team", Name: "team-reviews"})
+    client.RememberChannel(mattermost.Channel{ID: "c-service", Name: "reviews"})
+
+    return &mattermostChat{client}, fake
+}
+
+const listReviews = `[
+    {
+        "permaId": {"id": "CR-1"}, "name": "Первое", "projectKey": "P1", "state": "Review",
+        "author": {"userName": "alice"},
+        "reviewers": {"reviewer": [{"userName": "bob"}]}
+    },
+    {
+        "permaId": {"id": "CR-2"}, "name": "Второе", "projectKey": "P2", "state": "Review",
+        "author": {"userName": "bob"},
+        "reviewers": {"reviewer": [{"userName": "alice"}]}
+    }
+]`
+
+func TestReviewListMattermost(t *testing.T) {
+    CONFIG.Set(Config{
+        Mattermost: mattermost.Config{Channel: "reviews"},
+        UserMap: map[string]UserConfig{
+            "alice": {Slack: "alice.slack", Mattermost: "alice.mm"},
+            "bob": {Slack: "bob.slack", Mattermost: "bob.mm"},
+        },
+        ProjectMap: map[string]ProjectConfig{
+            "P1": {Channel: "slack-p1", MattermostChannel: "team-reviews"},
+            "P2": {Channel: "slack-p2"},
+        },
+    })
+    t.Cleanup(func() { CONFIG.Set(Config{}) })
+
+    crucibleClient := newFakeCrucible(t, listReviews)
+
+    for _, test := range []struct {
+        channelID, channelName string
+        want []string
+    }{
+        // Канал проекта из mattermostChannel — только его ревью
+        {"c-team", "team-reviews", []string{"Первое"}},
+        // Служебный канал — все ревью
+        {"c-service", "reviews", []string{"Первое", "Второе"}},
+    } {
+        chat, fake := newFakeMattermostChat(t)
+
+        commandReviewList(context.Background(), chat, crucibleClient, SlackMessage{
+            ChannelID: test.channelID,
+            ChannelName: test.channelName,
+            Text: "review list",
+            Time: time.Now(),
+        })
+
+        if len(fake.posts) != 2 {
+            t.Fatalf("%s: отправлено %d сообщений, ожидалось 2", test.channelName, len(fake.posts))
+        }
+
+        list := fake.posts[1]
+
+        if list.ChannelID != test.channelID {
+            t.Errorf("%s: список отправлен в %q", test.channelName, list.ChannelID)
+        }
+
+        var attachments []struct {
+            Title string `json:"title"`
+            AuthorName string `json:"author_name"`
+            Text string `json:"text"`
+        }
+
+        data, _ := json.Marshal(list.Props["attachments"])
+        json.Unmarshal(data, &attachments)
+
+        titles := []string{}
+
+        for _, attachment := range attachments {
+            titles = append(titles, attachment.Title)
+
+            if strings.Contains(attachment.AuthorName+attachment.Text, ".slack") {
+                t.Errorf("%s: ники Slack в Mattermost: %+v", test.channelName, attachment)
+            }
+        }
+
+        if strings.Join(titles, ", ") != strings.Join(test.want, ", ") {
+            t.Errorf("%s: ревью %q, ожидались %q", test.channelName, titles, test.want)
+        }
+
+        if len(attachments) > 0 && (attachments[0].AuthorName != "@alice.mm" || !strings.Contains(attachments[0].Text, "Ждём: @bob.mm")) {
+            t.Errorf("%s: ники не из Mattermost: %+v", test.channelName, attachments[0])
+        }
+    }
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+    "./crucible"
+    "./slack"
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "sync"
+)
+
+// Максимальная длина фрагмента комментария в сообщении
+const commentSnippetLength = 300
+
+type CommentEvent struct {
+    ProjectName string
+    Review crucible.Review
+    Comment crucible.Comment
+    // Автор комментария, на который ответили, пусто для комментария верхнего уровня
+    ParentAuthor string
+    // Путь к файлу для комментария к строкам
+    Path string
+}
+
+func (event *CommentEvent) IsReply() bool {
+    return event.ParentAuthor != ""
+}
+
+/* Треды ревью в Slack */
+
+type ReviewThread struct {
+    Channel string
+    Ts string
+}
+
+type ThreadStore struct {
+    mutex sync.Mutex
+    threads map[string]ReviewThread
+}
+
+var reviewThreads = ThreadStore{threads: map[string]ReviewThread{}}
+
+func (store *ThreadStore) Get(reviewID string) (thread ReviewThread, ok bool) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+
+    thread, ok = store.threads[reviewID]
+    return
+}
+
+func (store *ThreadStore) Set(reviewID string, thread ReviewThread) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+
+    store.threads[reviewID] = thread
+}
+
+/*
+    Отслеживает новые комментарии и ответы в ревью в работе
+ */
+type CommentWatcher struct {
+    crucibleClient *crucible.Crucible
+    // id ревью -> id уже известных комментариев
+    seen map[string]map[string]bool
+}
+
+func NewCommentWatcher(crucibleClient *crucible.Crucible) *CommentWatcher {
+    return &CommentWatcher{
+        crucibleClient: crucibleClient,
+        seen: map[string]map[string]bool{},
+    }
+}
+
+/*
+    Проверяет комментарии ревью в работе и посылает события о новых в `eventChannel`.
+    Комментарии ревью, которое встретилось впервые, запоминаются без уведомлений.
+ */
+func (watcher *CommentWatcher) Poll(ctx context.Context, projectName string, reviews []crucible.Review, eventChannel chan CommentEvent) {
+    active := map[string]bool{}
+
+    for _, review := range reviews {
+        if !review.GetState().IsActive() {
+            continue
+        }
+
+        active[review.GetID()] = true
+        watcher.PollReview(ctx, projectName, review, eventChannel)
+    }
+
+    // Завершённые ревью больше не отслеживаем
+    for reviewID := range watcher.seen {
+        if !active[reviewID] {
+            delete(watcher.seen, reviewID)
+        }
+    }
+}
+
+/*
+    Проверяет комментарии одного ревью, например после вызова webhook из Crucible
+ */
+func (watcher *CommentWatcher) PollReview(ctx context.Context, projectName string, review crucible.Review, eventChannel chan CommentEvent) {
+    reviewID := review.GetID()
+
+    comments, err := watcher.crucibleClient.GetComments(ctx, reviewID)
+
+    if err != nil {
+        log.Println("Ошибка получения комментариев", reviewID, err)
+        return
+    }
+
+    seen, known := watcher.seen[reviewID]
+
+    if !known {
+        seen = map[string]bool{}
+        watcher.seen[reviewID] = seen
+    }
+
+    var items *crucible.ReviewItemList
+
+    comments.Walk(func(comment crucible.Comment, parent *crucible.Comment) {
+        if comment.Draft || comment.Deleted || seen[comment.GetID()] {
+            return
+        }
+
+        seen[comment.GetID()] = true
+
+        if !known {
+            return
+        }
+
+        event := CommentEvent{
+            ProjectName: projectName,
+            Review: review,
+            Comment: comment,
+        }
+
+        if parent != nil {
+            event.ParentAuthor = parent.GetAuthorNick()
+            eventsTotal.Inc("reply")
+        } else {
+            eventsTotal.Inc("comment")
+        }
+
+        if comment.IsInline() {
+            if items == nil {
+                list, err := watcher.crucibleClient.GetReviewItems(ctx, reviewID)
+
+                if err != nil {
+                    log.Println("Ошибка получения файлов ревью", reviewID, err)
+                }
+
+                items = &list
+            }
+
+            if item, ok := items.FindById(comment.ReviewItemID.ID); ok {
+                event.Path = item.GetPath()
+            }
+        }
+
+        eventChannel <- event
+    })
+}
+
+func truncateText(text string, limit int) string {
+    runes := []rune(strings.TrimSpace(text))
+
+    if len(runes) <= limit {
+        return string(runes)
+    }
+
+    return strings.TrimSpace(string(runes[:limit])) + "…"
+}
+
+/*
+    Тред ревью в Slack, если его ещё нет — создаётся сообщением со ссылкой на ревью
+ */
+func getReviewThread(ctx context.Context, slackClient slack.SlackClient, projectName string, review crucible.Review) (thread ReviewThread, err error) {
+    thread, ok := reviewThreads.Get(review.GetID())
+
+    if ok {
+        return
+    }
+
+    title := review.Name
+    if title == "" {
+        title = review.GetID()
+    }
+
+    message := slack.Message{
+        Text: "Обсуждение ревью",
+        Channel: projectChannel(projectName),
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    message.AddAttachment(slack.Attachment{
+        AuthorName: MapUserNicks([]string{review.GetAuthorNick()}),
+        Title:      title,
+        TitleLink:  review.GetURL(CONFIG.Get().Crucible.Host),
+    })
+
+    posted, err := slackClient.PostMessage(ctx, message)
+
+    if err != nil {
+        return
+    }
+
+    thread = ReviewThread{Channel: posted.Channel, Ts: posted.Ts}
+    reviewThreads.Set(review.GetID(), thread)
+    return
+}
+
+/*
+    Рассылка комментариев в треды ревью, работает пока канал `commentEvents` не закрыт
+ */
+func listenCommentUpdate(ctx context.Context, commentEvents chan CommentEvent, slackClient slack.SlackClient, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    for event := range commentEvents {
+
+        project := CONFIG.Get().Project(event.ProjectName)
+
+        if !project.EventEnabled(EventComment) {
+            continue
+        }
+
+        comment := event.Comment
+        author := project.MapUserNicks([]string{comment.GetAuthorNick()})
+
+        text := fmt.Sprintf("%s оставил комментарий", author)
+
+        if event.IsReply() {
+            text = fmt.Sprintf("%s ответил %s", author, project.MapUserNicks([]string{event.ParentAuthor}))
+        }
+
+        if comment.DefectRaised {
+            text = ":warning: Дефект. " + text
+        }
+
+        log.Println("Новый комментарий", event.Review.GetID(), comment.GetID(), text)
+
+        thread, err := getReviewThread(ctx, slackClient, event.ProjectName, event.Review)
+
+        if err != nil {
+            log.Println("Ошибка создания треда ревью", event.Review.GetID(), err)
+            continue
+        }
+
+        attachment := slack.Attachment{
+            Title:     event.Review.Name,
+            TitleLink: event.Review.GetURL(CONFIG.Get().Crucible.Host),
+            Text:      truncateText(comment.Message, commentSnippetLength),
+        }
+
+        if attachment.Title == "" {
+            attachment.Title = event.Review.GetID()
+        }
+
+        if comment.IsInline() {
+            location := event.Path
+
+            if location == "" {
+                location = comment.ReviewItemID.ID
+            }
+
+            if line := comment.GetLine(); line != "" {
+                location = fmt.Sprintf("%s:%s", location, line)
+            }
+
+            attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+                Title: "Файл",
+                Value: location,
+            })
+        }
+
+        if comment.DefectRaised {
+            attachment.Color = "danger"
+        }
+
+        slackMessage := slack.Message{
+            Text: text,
+            Channel: thread.Channel,
+            ThreadTs: thread.Ts,
+            IconUrl: "http://lorempixel.com/48/48/cats/",
+            AsUser: false,
+        }
+
+        slackMessage.AddAttachment(attachment)
+
+        _, err = slackClient.PostMessage(ctx, slackMessage)
+
+        if err != nil {
+            log.Println("Ошибка отправки комментария", err)
+        }
+    }
+}
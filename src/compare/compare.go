@@ -3,16 +3,49 @@ package compare
 import (
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 )
 
 type CompareResult struct {
+	//Dotted path to the field, slice and map elements are indexed: Reviewers.Reviewer[2].Completed
 	FieldName string
-	Value1    interface{}
-	Value2    interface{}
+	//Values of the field, nil if the element exists only on one side
+	Value1 interface{}
+	Value2 interface{}
 }
 
+//Comparer holds options of the recursive comparison, the zero value is ready to use
+type Comparer struct {
+	//Key fields to match slice elements by instead of by index.
+	//Keyed by the slice path without indexes, e.g. "Reviewers.Reviewer": "UserName"
+	SliceKeys map[string]string
+	//Paths without indexes to skip, e.g. "Reviewers.Reviewer.AvatarURL"
+	Ignore []string
+}
+
+type visit struct {
+	ptr1, ptr2 uintptr
+	typ        reflect.Type
+}
+
+type comparison struct {
+	*Comparer
+	differences []*CompareResult
+	//Pointer pairs on the current path, a shared pointer reached by another path is compared again
+	visiting map[visit]bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+//Compare compares two values of the same type with the default options
 func Compare(struct1 interface{}, struct2 interface{}) (areEqual bool, differences []*CompareResult, err error) {
+	return (&Comparer{}).Compare(struct1, struct2)
+}
+
+//Compare walks both values recursively and returns the differing leaf fields
+func (comparer *Comparer) Compare(struct1 interface{}, struct2 interface{}) (areEqual bool, differences []*CompareResult, err error) {
 
 	if struct1 == nil || struct2 == nil {
 		return false, nil, fmt.Errorf("One of the inputs cannot be nil. struct1: %v, struct2 : %v ", struct1, struct2)
@@ -21,77 +54,272 @@ func Compare(struct1 interface{}, struct2 interface{}) (areEqual bool, differenc
 	//Get values of the structs
 	v1, v2 := reflect.ValueOf(struct1), reflect.ValueOf(struct2)
 
-	//Handle pointers, if a non-pointer struct is passed in, Indirect will just return the element
-	v1, v2 = reflect.Indirect(v1), reflect.Indirect(v2)
-	if !v1.IsValid() || !v2.IsValid() {
-		return false, nil, fmt.Errorf("Types cannot be nil. v1 %v - v2 %v", v1.IsValid(), v2.IsValid())
+	//Verify both v1 and v2 are the same type
+	if v1.Type() != v2.Type() {
+		return false, nil, fmt.Errorf("Structs must be the same type. Struct1 %v - Stuct2 -%v", v1.Type(), v2.Type())
 	}
 
-	//Cache v1 struct type
-	structType := v1.Type()
+	state := &comparison{Comparer: comparer, differences: make([]*CompareResult, 0), visiting: map[visit]bool{}}
 
-	//Verify both v1 and v2 are the same type
-	if structType != v2.Type() {
-		return false, nil, fmt.Errorf("Structs must be the same type. Struct1 %v - Stuct2 -%v", structType, v2.Type())
+	if err = state.compare(v1, v2, "", ""); err != nil {
+		return false, nil, err
 	}
 
-	//Verify v1 is a struct, if v1 is a struct then v2 is also a struct because we have already verified the types are equal
-	if v1.Kind() != reflect.Struct {
-		return false, nil, fmt.Errorf("Types must both be structs.  Kind1: %v, Kind2 :v", v1.Kind(), v2.Kind())
+	return len(state.differences) == 0, state.differences, nil
+}
+
+func (state *comparison) add(path string, v1 reflect.Value, v2 reflect.Value) {
+	state.differences = append(state.differences, &CompareResult{FieldName: path, Value1: valueOf(v1), Value2: valueOf(v2)})
+}
+
+func (state *comparison) ignored(pattern string) bool {
+	for _, ignore := range state.Ignore {
+		if ignore == pattern {
+			return true
+		}
 	}
 
-	//Initialize differences to ensure length of 0 on return
-	differences = make([]*CompareResult, 0)
+	return false
+}
+
+//compare compares v1 and v2 of the same type. path is the reported field path,
+//pattern is the same path without indexes used to look up options
+func (state *comparison) compare(v1 reflect.Value, v2 reflect.Value, path string, pattern string) error {
+	if state.ignored(pattern) {
+		return nil
+	}
 
-	for i, numFields := 0, v1.NumField(); i < numFields; i++ {
-		//Get values of the structure's fields
-		field1, field2 := v1.Field(i), v2.Field(i)
+	switch v1.Kind() {
+	case reflect.Ptr:
+		if v1.IsNil() && v2.IsNil() {
+			return nil
+		}
 
-		//Get a reference to the field type
-		fieldType := structType.Field(i)
+		if !v1.IsNil() && !v2.IsNil() {
+			if v1.Pointer() == v2.Pointer() {
+				return nil
+			}
 
-		//If the field name is unexported, skip
-		if fieldType.PkgPath != "" {
-			continue
+			//Guard against cyclic structures
+			key := visit{v1.Pointer(), v2.Pointer(), v1.Type()}
+			if state.visiting[key] {
+				return nil
+			}
+			state.visiting[key] = true
+			defer delete(state.visiting, key)
 		}
 
-		//Handle nil pointers, if a non-pointer field is passed in, Indirect will just return the element
-		field1, field2 = reflect.Indirect(field1), reflect.Indirect(field2)
-
-		switch valid1, valid2 := field1.IsValid(), field2.IsValid(); {
-		//If both are valid, do nothing
-		case valid1 && valid2:
-		//If only field1 is valid, set field2 to reflect.Zero
-		case valid1:
-			field2 = reflect.Zero(field1.Type())
-		//If only field1 is valid, set field2 to reflect.Zero
-		case valid2:
-			field1 = reflect.Zero(field2.Type())
-		//Both are invalid so skip loop body
-		default:
-			continue
+		//A nil pointer is compared as the zero value of its element
+		return state.compare(indirect(v1), indirect(v2), path, pattern)
+
+	case reflect.Interface:
+		if v1.IsNil() && v2.IsNil() {
+			return nil
+		}
+
+		//Values of different dynamic types are reported as a whole
+		if v1.IsNil() || v2.IsNil() || v1.Elem().Type() != v2.Elem().Type() {
+			state.add(path, v1, v2)
+			return nil
+		}
+
+		return state.compare(v1.Elem(), v2.Elem(), path, pattern)
+
+	case reflect.Struct:
+		if v1.Type() == timeType {
+			if !v1.Interface().(time.Time).Equal(v2.Interface().(time.Time)) {
+				state.add(path, v1, v2)
+			}
+			return nil
+		}
+
+		for i, numFields := 0, v1.NumField(); i < numFields; i++ {
+			fieldType := v1.Type().Field(i)
+
+			//If the field name is unexported, skip
+			if fieldType.PkgPath != "" {
+				continue
+			}
+
+			err := state.compare(v1.Field(i), v2.Field(i), join(path, fieldType.Name), join(pattern, fieldType.Name))
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+
+	case reflect.Slice, reflect.Array:
+		if v1.Kind() == reflect.Slice && v1.IsNil() && v2.IsNil() {
+			return nil
+		}
+
+		if key, ok := state.SliceKeys[pattern]; ok {
+			return state.compareKeyed(v1, v2, path, pattern, key)
 		}
 
-		if field1.Kind() == reflect.Interface {
-			return false, nil, fmt.Errorf("Type of field cannot be interface. field1: %v, field2: %v", field1, field2)
+		for i := 0; i < v1.Len() || i < v2.Len(); i++ {
+			elementPath := fmt.Sprintf("%s[%d]", path, i)
+
+			switch {
+			case i >= v1.Len():
+				state.add(elementPath, reflect.Value{}, v2.Index(i))
+			case i >= v2.Len():
+				state.add(elementPath, v1.Index(i), reflect.Value{})
+			default:
+				if err := state.compare(v1.Index(i), v2.Index(i), elementPath, pattern); err != nil {
+					return err
+				}
+			}
 		}
 
+		return nil
+
+	case reflect.Map:
+		if v1.IsNil() && v2.IsNil() {
+			return nil
+		}
 
+		//Keys of both maps, each once. Keys are compared as values, their text is only for paths and order
+		keys := v1.MapKeys()
+		for _, key := range v2.MapKeys() {
+			if !v1.MapIndex(key).IsValid() {
+				keys = append(keys, key)
+			}
+		}
 
+		sort.SliceStable(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
 
-		val1, val2 := field1.Interface(), field2.Interface();
+		for _, key := range keys {
+			elementPath := fmt.Sprintf("%s[%v]", path, key.Interface())
+			element1, element2 := v1.MapIndex(key), v2.MapIndex(key)
 
-		switch val1.(type) {
-		case int, bool, string, float64, time.Time:
-			if val1 != val2 {
-				result := &CompareResult{FieldName: fieldType.Name, Value1: val1, Value2: val2}
-				differences = append(differences, result)
+			switch {
+			case !element1.IsValid():
+				state.add(elementPath, reflect.Value{}, element2)
+			case !element2.IsValid():
+				state.add(elementPath, element1, reflect.Value{})
+			default:
+				if err := state.compare(element1, element2, elementPath, pattern); err != nil {
+					return err
+				}
 			}
-		default:
-			return false, nil, fmt.Errorf("Unsupported type: %v %v", field1.Type(), val1)
 		}
 
+		return nil
+
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
+		reflect.String:
+		if valueOf(v1) != valueOf(v2) {
+			state.add(path, v1, v2)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported type: %v at %q", v1.Type(), path)
+}
+
+//compareKeyed matches slice elements by the key field, elements are reported
+//by their index in the second slice, removed ones by the index in the first
+func (state *comparison) compareKeyed(v1 reflect.Value, v2 reflect.Value, path string, pattern string, key string) error {
+	elementType := v1.Type().Elem()
+	for elementType.Kind() == reflect.Ptr {
+		elementType = elementType.Elem()
+	}
+
+	if elementType.Kind() != reflect.Struct {
+		return fmt.Errorf("Key %q requires a slice of structs at %q, got %v", key, path, v1.Type())
+	}
+
+	if field, ok := elementType.FieldByName(key); !ok || !field.Type.Comparable() {
+		return fmt.Errorf("Key %q is not a comparable field of %v at %q", key, elementType, path)
+	}
+
+	keyOf := func(element reflect.Value) interface{} {
+		return valueOf(indirect(element).FieldByName(key))
+	}
+
+	//Elements with the same key are matched in order of appearance
+	index1 := map[interface{}][]int{}
+	for i := 0; i < v1.Len(); i++ {
+		key := keyOf(v1.Index(i))
+		index1[key] = append(index1[key], i)
+	}
+
+	matched := map[int]bool{}
+
+	for i := 0; i < v2.Len(); i++ {
+		elementPath := fmt.Sprintf("%s[%d]", path, i)
+		key := keyOf(v2.Index(i))
+		candidates := index1[key]
+
+		if len(candidates) == 0 {
+			state.add(elementPath, reflect.Value{}, v2.Index(i))
+			continue
+		}
+
+		j := candidates[0]
+		index1[key] = candidates[1:]
+		matched[j] = true
+
+		if err := state.compare(v1.Index(j), v2.Index(i), elementPath, pattern); err != nil {
+			return err
+		}
 	}
 
-	return len(differences) == 0, differences, nil
-}
\ No newline at end of file
+	for j := 0; j < v1.Len(); j++ {
+		if !matched[j] {
+			state.add(fmt.Sprintf("%s[%d]", path, j), v1.Index(j), reflect.Value{})
+		}
+	}
+
+	return nil
+}
+
+//indirect dereferences pointers, a nil pointer becomes the zero value of its element
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+			continue
+		}
+		v = v.Elem()
+	}
+
+	return v
+}
+
+//valueOf returns the value as interface{}, nil for an invalid value
+func valueOf(v reflect.Value) interface{} {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil
+	}
+
+	return v.Interface()
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
+
+//Changed reports whether any difference is at the path or below it
+func Changed(differences []*CompareResult, path string) bool {
+	for _, difference := range differences {
+		name := difference.FieldName
+		if name == path || strings.HasPrefix(name, path+".") || strings.HasPrefix(name, path+"[") {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,237 @@
+package compare
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type reviewer struct {
+	UserName  string
+	Completed bool
+}
+
+type review struct {
+	Name      string
+	Author    struct{ UserName string }
+	Reviewers struct{ Reviewer []reviewer }
+	Labels    map[string]string
+	Extra     interface{}
+	Closed    *time.Time
+}
+
+//paths returns the sorted field paths of the differences
+func paths(t *testing.T, comparer *Comparer, v1 interface{}, v2 interface{}) []string {
+	t.Helper()
+
+	equal, differences, err := comparer.Compare(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if equal != (len(differences) == 0) {
+		t.Errorf("areEqual %v with %d differences", equal, len(differences))
+	}
+
+	result := []string{}
+	for _, difference := range differences {
+		result = append(result, difference.FieldName)
+	}
+	sort.Strings(result)
+
+	return result
+}
+
+func expectPaths(t *testing.T, got []string, want ...string) {
+	t.Helper()
+
+	if want == nil {
+		want = []string{}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("paths %q, want %q", got, want)
+	}
+}
+
+func TestCompareNested(t *testing.T) {
+	v1, v2 := review{Name: "a"}, review{Name: "b"}
+	v1.Author.UserName, v2.Author.UserName = "x", "y"
+	v1.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r2", false}, {"r3", false}}
+	v2.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r2", false}, {"r3", true}}
+
+	expectPaths(t, paths(t, &Comparer{}, v1, v2), "Author.UserName", "Name", "Reviewers.Reviewer[2].Completed")
+	expectPaths(t, paths(t, &Comparer{}, v1, v1))
+}
+
+func TestCompareIgnore(t *testing.T) {
+	v1, v2 := review{Name: "a"}, review{Name: "b"}
+	v1.Reviewers.Reviewer = []reviewer{{"r1", false}}
+	v2.Reviewers.Reviewer = []reviewer{{"r1", true}}
+
+	comparer := &Comparer{Ignore: []string{"Name", "Reviewers.Reviewer.Completed"}}
+	expectPaths(t, paths(t, comparer, v1, v2))
+}
+
+func TestCompareSliceByIndex(t *testing.T) {
+	v1, v2 := review{}, review{}
+	v1.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r2", false}}
+	v2.Reviewers.Reviewer = []reviewer{{"r2", false}}
+
+	expectPaths(t, paths(t, &Comparer{}, v1, v2), "Reviewers.Reviewer[0].UserName", "Reviewers.Reviewer[1]")
+}
+
+func TestCompareSliceByKey(t *testing.T) {
+	comparer := &Comparer{SliceKeys: map[string]string{"Reviewers.Reviewer": "UserName"}}
+
+	v1, v2 := review{}, review{}
+	v1.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r2", false}, {"r3", false}}
+	v2.Reviewers.Reviewer = []reviewer{{"r3", true}, {"r4", false}, {"r2", false}}
+
+	_, differences, err := comparer.Compare(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string][2]interface{}{}
+	for _, difference := range differences {
+		got[difference.FieldName] = [2]interface{}{difference.Value1, difference.Value2}
+	}
+
+	want := map[string][2]interface{}{
+		//Matched by key, reported by the index in the second slice
+		"Reviewers.Reviewer[0].Completed": {false, true},
+		//Added and removed elements are reported as a whole
+		"Reviewers.Reviewer[1]": {nil, reviewer{"r4", false}},
+		"Reviewers.Reviewer[0]": {reviewer{"r1", false}, nil},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("differences %v, want %v", got, want)
+	}
+}
+
+func TestCompareSliceDuplicateKeys(t *testing.T) {
+	comparer := &Comparer{SliceKeys: map[string]string{"Reviewers.Reviewer": "UserName"}}
+
+	v1, v2 := review{}, review{}
+	v1.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r1", true}}
+	v2.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r1", true}}
+
+	//Duplicates are matched in order, not all against the last one
+	expectPaths(t, paths(t, comparer, v1, v2))
+
+	v2.Reviewers.Reviewer = []reviewer{{"r1", false}}
+	expectPaths(t, paths(t, comparer, v1, v2), "Reviewers.Reviewer[1]")
+
+	v2.Reviewers.Reviewer = []reviewer{{"r1", false}, {"r1", true}, {"r1", true}}
+	expectPaths(t, paths(t, comparer, v1, v2), "Reviewers.Reviewer[2]")
+}
+
+func TestCompareSliceKeyErrors(t *testing.T) {
+	type list struct{ Items []string }
+
+	comparer := &Comparer{SliceKeys: map[string]string{"Items": "Name"}}
+	if _, _, err := comparer.Compare(list{}, list{Items: []string{"a"}}); err == nil {
+		t.Error("expected an error for a key on a slice of strings")
+	}
+
+	comparer = &Comparer{SliceKeys: map[string]string{"Reviewers.Reviewer": "Missing"}}
+	v2 := review{}
+	v2.Reviewers.Reviewer = []reviewer{{"r1", false}}
+	if _, _, err := comparer.Compare(review{}, v2); err == nil {
+		t.Error("expected an error for an unknown key field")
+	}
+}
+
+func TestCompareMap(t *testing.T) {
+	v1 := review{Labels: map[string]string{"a": "1", "b": "2"}}
+	v2 := review{Labels: map[string]string{"b": "3", "c": "4"}}
+
+	expectPaths(t, paths(t, &Comparer{}, v1, v2), "Labels[a]", "Labels[b]", "Labels[c]")
+	expectPaths(t, paths(t, &Comparer{}, review{}, review{Labels: map[string]string{}}))
+}
+
+func TestCompareMapKeyCollision(t *testing.T) {
+	//1 and "1" print the same, but are different keys
+	v1 := map[interface{}]int{1: 1, "1": 2}
+	v2 := map[interface{}]int{1: 1, "1": 3}
+
+	_, differences, err := Compare(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(differences) != 1 || differences[0].Value1 != 2 || differences[0].Value2 != 3 {
+		t.Fatalf("differences %+v, want only \"1\": 2 -> 3", differences)
+	}
+
+	v2 = map[interface{}]int{1: 1}
+	_, differences, err = Compare(v1, v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(differences) != 1 || differences[0].Value1 != 2 || differences[0].Value2 != nil {
+		t.Fatalf("differences %+v, want only removed \"1\"", differences)
+	}
+}
+
+func TestCompareInterface(t *testing.T) {
+	expectPaths(t, paths(t, &Comparer{}, review{Extra: []interface{}{1, "a"}}, review{Extra: []interface{}{1, "b"}}), "Extra[1]")
+	expectPaths(t, paths(t, &Comparer{}, review{Extra: 1}, review{Extra: "1"}), "Extra")
+	expectPaths(t, paths(t, &Comparer{}, review{}, review{Extra: 1}), "Extra")
+	expectPaths(t, paths(t, &Comparer{}, review{Extra: map[string]interface{}{"a": 1}}, review{Extra: map[string]interface{}{"a": 1}}))
+}
+
+func TestComparePointersAndTime(t *testing.T) {
+	moment := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sameMoment := moment.In(time.FixedZone("UTC+3", 3*60*60))
+	later := moment.Add(time.Minute)
+
+	expectPaths(t, paths(t, &Comparer{}, review{Closed: &moment}, review{Closed: &sameMoment}))
+	expectPaths(t, paths(t, &Comparer{}, review{Closed: &moment}, review{Closed: &later}), "Closed")
+	//A nil pointer is compared as the zero value
+	expectPaths(t, paths(t, &Comparer{}, review{}, review{Closed: &moment}), "Closed")
+	expectPaths(t, paths(t, &Comparer{}, review{}, review{Closed: &time.Time{}}))
+	expectPaths(t, paths(t, &Comparer{}, &review{Name: "a"}, &review{Name: "b"}), "Name")
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestCompareCycle(t *testing.T) {
+	a := &node{Value: 1}
+	a.Next = a
+	b := &node{Value: 2}
+	b.Next = b
+
+	expectPaths(t, paths(t, &Comparer{}, a, b), "Value")
+}
+
+func TestCompareSharedPointer(t *testing.T) {
+	type pair struct{ Left, Right *node }
+
+	shared1, shared2 := &node{Value: 1}, &node{Value: 2}
+
+	//The same pointers on two paths: both paths are reported
+	expectPaths(t, paths(t, &Comparer{}, pair{shared1, shared1}, pair{shared2, shared2}), "Left.Value", "Right.Value")
+}
+
+func TestCompareErrors(t *testing.T) {
+	if _, _, err := Compare(nil, review{}); err == nil {
+		t.Error("expected an error for nil")
+	}
+
+	if _, _, err := Compare(review{}, reviewer{}); err == nil {
+		t.Error("expected an error for different types")
+	}
+
+	type withFunc struct{ F func() }
+	if _, _, err := Compare(withFunc{}, withFunc{}); err == nil {
+		t.Error("expected an error for a func field")
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "gopkg.in/yaml.v3"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// Префикс переменных окружения, переопределяющих конфиг
+const configEnvPrefix = "REVIEWBOT_"
+
+// Файл конфига по умолчанию, переопределяется флагом --config или REVIEWBOT_CONFIG
+const defaultConfigPath = "config.json"
+
+// Чем заменяются секреты при выводе конфига
+const redacted = "***"
+
+var configPath = defaultConfigPath
+
+/*
+    Путь к конфигу из переменной окружения, если флаг --config не указан
+ */
+func configPathFromEnv() string {
+    if path := os.Getenv(configEnvPrefix + "CONFIG"); path != "" {
+        return path
+    }
+
+    return defaultConfigPath
+}
+
+/*
+    Итоговый конфиг: файл json или yaml, поверх него переменные окружения REVIEWBOT_*,
+    затем секреты из файлов (`passwordFile`, `tokenFile`). Ошибки проверки конфига
+    возвращаются все сразу в ConfigErrors
+ */
+func getConfig(path string) (config Config, err error) {
+    return readConfig(path, validateConfig)
+}
+
+/*
+    Конфиг для команд, которым нужен только Crucible (export): остальные разделы не проверяются
+ */
+func getCrucibleConfig(path string) (config Config, err error) {
+    return readConfig(path, validateCrucible)
+}
+
+func readConfig(path string, validate func(Config) ConfigErrors) (config Config, err error) {
+    data, err := readConfigFile(path)
+
+    if err != nil {
+        return
+    }
+
+    err = json.Unmarshal(data, &config)
+
+    if err != nil {
+        return config, errors.New(fmt.Sprint(path, ": ", err))
+    }
+
+    err = applyEnv(reflect.ValueOf(&config).Elem(), configEnvPrefix)
+
+    if err != nil {
+        return
+    }
+
+    err = readSecretFiles(reflect.ValueOf(&config).Elem())
+
+    if err != nil {
+        return
+    }
+
+    var raw interface{}
+    json.Unmarshal(data, &raw)
+
+    errs := unknownConfigKeys(raw, reflect.TypeOf(config), "")
+    errs = append(errs, validate(config)...)
+
+    if len(errs) > 0 {
+        return config, errs
+    }
+
+    return
+}
+
+/*
+    Содержимое файла конфига в json, yaml разбирается через промежуточный json,
+    чтобы использовать те же имена полей
+ */
+func readConfigFile(path string) (data []byte, err error) {
+    data, err = ioutil.ReadFile(path)
+
+    if err != nil {
+        return
+    }
+
+    switch strings.ToLower(filepath.Ext(path)) {
+    case ".yaml", ".yml":
+        var raw interface{}
+
+        err = yaml.Unmarshal(data, &raw)
+
+        if err != nil {
+            return nil, errors.New(fmt.Sprint(path, ": ", err))
+        }
+
+        return json.Marshal(raw)
+    }
+
+    return
+}
+
+/*
+    Имя поля в конфиге: из тега json, иначе имя поля структуры
+ */
+func configFieldName(field reflect.StructField) string {
+    name := strings.Split(field.Tag.Get("json"), ",")[0]
+
+    if name == "" {
+        name = field.Name
+    }
+
+    return name
+}
+
+/*
+    Имя переменной окружения для поля: escalateAfter -> ESCALATE_AFTER
+ */
+func envName(name string) string {
+    var result []rune
+    runes := []rune(name)
+
+    for i, r := range runes {
+        if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+            result = append(result, '_')
+        }
+
+        result = append(result, unicode.ToUpper(r))
+    }
+
+    return string(result)
+}
+
+/*
+    Переопределяет поля конфига переменными окружения: REVIEWBOT_SLACK_TOKEN, REVIEWBOT_CRUCIBLE_TIMEOUT.
+    Значение из файла — в переменной с суффиксом _FILE. Карты и списки задаются в JSON:
+    REVIEWBOT_PROJECT_MAP='{"CR": "#reviews"}'
+ */
+func applyEnv(value reflect.Value, prefix string) (err error) {
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+
+        if field.PkgPath != "" {
+            continue
+        }
+
+        name := prefix + envName(configFieldName(field))
+
+        if field.Type.Kind() == reflect.Struct {
+            err = applyEnv(value.Field(i), name+"_")
+
+            if err != nil {
+                return
+            }
+
+            continue
+        }
+
+        env, ok := os.LookupEnv(name)
+
+        if file := os.Getenv(name + "_FILE"); file != "" {
+            env, err = readSecretFile(file)
+
+            if err != nil {
+                return
+            }
+
+            ok = true
+        }
+
+        if !ok {
+            continue
+        }
+
+        err = setFromString(value.Field(i), env)
+
+        if err != nil {
+            return errors.New(fmt.Sprint(name, ": ", err))
+        }
+    }
+
+    return
+}
+
+func setFromString(field reflect.Value, value string) (err error) {
+    switch field.Kind() {
+    case reflect.String:
+        field.SetString(value)
+    case reflect.Bool:
+        parsed, err := strconv.ParseBool(value)
+
+        if err != nil {
+            return err
+        }
+
+        field.SetBool(parsed)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        parsed, err := strconv.ParseInt(value, 10, 64)
+
+        if err != nil {
+            return err
+        }
+
+        field.SetInt(parsed)
+    case reflect.Float32, reflect.Float64:
+        parsed, err := strconv.ParseFloat(value, 64)
+
+        if err != nil {
+            return err
+        }
+
+        field.SetFloat(parsed)
+    default:
+        return json.Unmarshal([]byte(value), field.Addr().Interface())
+    }
+
+    return
+}
+
+func readSecretFile(path string) (secret string, err error) {
+    data, err := ioutil.ReadFile(path)
+
+    if err != nil {
+        return
+    }
+
+    return strings.TrimSpace(string(data)), nil
+}
+
+/*
+    Секреты (поля с тегом `secret`) из файлов, указанных в соседнем поле с суффиксом File.
+    Список секретов, например адреса webhook, читается из файла по одному в строке
+ */
+func readSecretFiles(value reflect.Value) (err error) {
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+
+        if field.PkgPath != "" {
+            continue
+        }
+
+        if field.Type.Kind() == reflect.Struct {
+            err = readSecretFiles(value.Field(i))
+
+            if err != nil {
+                return
+            }
+
+            continue
+        }
+
+        // Списки настроек, например hooks.endpoints
+        if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+            for j := 0; j < value.Field(i).Len(); j++ {
+                err = readSecretFiles(value.Field(i).Index(j))
+
+                if err != nil {
+                    return
+                }
+            }
+
+            continue
+        }
+
+        // Настройки по имени, например notifiers. Значения карты не изменяемы, меняется копия
+        if field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct {
+            for _, key := range value.Field(i).MapKeys() {
+                element := reflect.New(field.Type.Elem()).Elem()
+                element.Set(value.Field(i).MapIndex(key))
+
+                err = readSecretFiles(element)
+
+                if err != nil {
+                    return
+                }
+
+                value.Field(i).SetMapIndex(key, element)
+            }
+
+            continue
+        }
+
+        if field.Tag.Get("secret") == "" {
+            continue
+        }
+
+        file := value.FieldByName(field.Name + "File")
+
+        if !file.IsValid() || file.String() == "" {
+            continue
+        }
+
+        secret, err := readSecretFile(file.String())
+
+        if err != nil {
+            return err
+        }
+
+        if field.Type.Kind() == reflect.Slice {
+            value.Field(i).Set(reflect.ValueOf(strings.Fields(secret)))
+            continue
+        }
+
+        value.Field(i).SetString(secret)
+    }
+
+    return
+}
+
+/*
+    Копия конфига со скрытыми секретами для вывода
+ */
+func redactConfig(config Config) Config {
+    redactSecrets(reflect.ValueOf(&config).Elem())
+    return config
+}
+
+func redactSecrets(value reflect.Value) {
+    for i := 0; i < value.NumField(); i++ {
+        field := value.Type().Field(i)
+
+        if field.PkgPath != "" {
+            continue
+        }
+
+        if field.Type.Kind() == reflect.Struct {
+            redactSecrets(value.Field(i))
+            continue
+        }
+
+        if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+            // Копия списка, чтобы не испортить секреты в исходном конфиге
+            list := reflect.MakeSlice(field.Type, value.Field(i).Len(), value.Field(i).Len())
+            reflect.Copy(list, value.Field(i))
+
+            for j := 0; j < list.Len(); j++ {
+                redactSecrets(list.Index(j))
+            }
+
+            value.Field(i).Set(list)
+            continue
+        }
+
+        if field.Type.Kind() == reflect.Map && field.Type.Elem().Kind() == reflect.Struct {
+            if value.Field(i).IsNil() {
+                continue
+            }
+
+            // Новая карта, исходная общая с конфигом
+            redactedMap := reflect.MakeMapWithSize(field.Type, value.Field(i).Len())
+
+            for _, key := range value.Field(i).MapKeys() {
+                element := reflect.New(field.Type.Elem()).Elem()
+                element.Set(value.Field(i).MapIndex(key))
+                redactSecrets(element)
+                redactedMap.SetMapIndex(key, element)
+            }
+
+            value.Field(i).Set(redactedMap)
+            continue
+        }
+
+        if field.Tag.Get("secret") == "" {
+            continue
+        }
+
+        if field.Type.Kind() == reflect.Slice {
+            if value.Field(i).Len() > 0 {
+                list := make([]string, value.Field(i).Len())
+
+                for j := range list {
+                    list[j] = redacted
+                }
+
+                value.Field(i).Set(reflect.ValueOf(list))
+            }
+
+            continue
+        }
+
+        if value.Field(i).String() != "" {
+            value.Field(i).SetString(redacted)
+        }
+    }
+}
+
+/*
+    Выводит в json конфиг, с которым работает бот: проекты с настройками по умолчанию
+    (см. effectiveConfig), секреты скрыты
+ */
+func printConfig(writer io.Writer, config Config) (err error) {
+    data, err := json.MarshalIndent(redactConfig(effectiveConfig(config)), "", "  ")
+
+    if err != nil {
+        return
+    }
+
+    _, err = fmt.Fprintln(writer, string(data))
+    return
+}
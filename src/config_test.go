@@ -0,0 +1,100 @@
+package main
+
+import (
+    "bytes"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+const mattermostHookURL = "https://mattermost.example.com/hooks/secret-key"
+
+func TestPrintConfigRedactsNotifierURL(t *testing.T) {
+    config := Config{
+        Notifiers: map[string]NotifierConfig{
+            "team": {Type: NotifierMattermost, URL: mattermostHookURL, Channel: "reviews"},
+        },
+        Routes: []RouteRule{{Webhooks: []string{"https://hooks.example.com/T0/B0/secret"}}},
+    }
+
+    var output bytes.Buffer
+
+    if err := printConfig(&output, config); err != nil {
+        t.Fatal(err)
+    }
+
+    text := output.String()
+
+    if strings.Contains(text, "secret") {
+        t.Errorf("секрет в выводе конфига:\n%s", text)
+    }
+
+    if !strings.Contains(text, `"url": "`+redacted+`"`) || !strings.Contains(text, `"channel": "reviews"`) {
+        t.Errorf("адрес не скрыт или пропали другие поля:\n%s", text)
+    }
+
+    // Исходный конфиг не испорчен
+    if config.Notifiers["team"].URL != mattermostHookURL || config.Routes[0].Webhooks[0] == redacted {
+        t.Errorf("скрыты секреты исходного конфига: %+v", config)
+    }
+}
+
+func TestReadSecretFilesNotifierURL(t *testing.T) {
+    dir := t.TempDir()
+    urlFile := filepath.Join(dir, "url")
+    webhooksFile := filepath.Join(dir, "webhooks")
+
+    ioutil.WriteFile(urlFile, []byte(mattermostHookURL+"\n"), 0600)
+    ioutil.WriteFile(webhooksFile, []byte("https://a.example.com/1\n\nhttps://b.example.com/2\n"), 0600)
+
+    config := Config{
+        Notifiers: map[string]NotifierConfig{"team": {Type: NotifierMattermost, URLFile: urlFile}},
+        Routes: []RouteRule{{WebhooksFile: webhooksFile}},
+    }
+
+    if err := readSecretFiles(reflect.ValueOf(&config).Elem()); err != nil {
+        t.Fatal(err)
+    }
+
+    if url := config.Notifiers["team"].URL; url != mattermostHookURL {
+        t.Errorf("url = %q", url)
+    }
+
+    if webhooks := config.Routes[0].Webhooks; !reflect.DeepEqual(webhooks, []string{"https://a.example.com/1", "https://b.example.com/2"}) {
+        t.Errorf("webhooks = %q", webhooks)
+    }
+
+    config.Notifiers["team"] = NotifierConfig{URLFile: filepath.Join(dir, "missing")}
+
+    if err := readSecretFiles(reflect.ValueOf(&config).Elem()); !os.IsNotExist(err) {
+        t.Errorf("ожидалась ошибка чтения файла, получено %v", err)
+    }
+}
+
+func TestPrintConfigEffectiveProject(t *testing.T) {
+    config := Config{
+        ProjectMap: map[string]ProjectConfig{"CR": {}},
+        Reminders: ReminderConfig{After: 24, Leads: map[string]string{"CR": "@lead"}},
+    }
+    config.Crucible.Timeout = 30
+    config.Slack.Channel = "reviews"
+
+    var output bytes.Buffer
+
+    if err := printConfig(&output, config); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, want := range []string{`"pollInterval": 30`, `"channel": "reviews"`, `"completedReviewers": 2`, `"mentions": "all"`, `"after": 24`, `"lead": "@lead"`} {
+        if !strings.Contains(output.String(), want) {
+            t.Errorf("нет %s в выводе конфига:\n%s", want, output.String())
+        }
+    }
+
+    if config.ProjectMap["CR"].PollInterval != 0 {
+        t.Errorf("изменён исходный конфиг: %+v", config.ProjectMap["CR"])
+    }
+}
@@ -0,0 +1,133 @@
+package crucible
+
+import (
+    "context"
+    "fmt"
+    "net/url"
+)
+
+// https://docs.atlassian.com/fisheye-crucible/latest/wadl/crucible.html#d2e1022
+
+type User struct {
+    AvatarURL   string `json:"avatarUrl"`
+    DisplayName string `json:"displayName"`
+    URL         string `json:"url"`
+    UserName    string `json:"userName"`
+}
+
+type Comment struct {
+    PermaID struct {
+        ID string `json:"id"`
+    } `json:"permaId"`
+    Message        string    `json:"message"`
+    Draft          bool      `json:"draft"`
+    Deleted        bool      `json:"deleted"`
+    DefectRaised   bool      `json:"defectRaised"`
+    DefectApproved bool      `json:"defectApproved"`
+    User           User      `json:"user"`
+    CreateDate     Timestamp `json:"createDate"`
+    Replies        []Comment `json:"replies"`
+    // Для комментариев к строкам файла
+    ReviewItemID struct {
+        ID string `json:"id"`
+    } `json:"reviewItemId"`
+    FromLineRange string `json:"fromLineRange"`
+    ToLineRange   string `json:"toLineRange"`
+}
+
+func (comment *Comment) GetID() string {
+    return comment.PermaID.ID
+}
+
+func (comment *Comment) GetAuthorNick() string {
+    return comment.User.UserName
+}
+
+/*
+    Комментарий к строкам файла, а не общий комментарий к ревью
+ */
+func (comment *Comment) IsInline() bool {
+    return comment.ReviewItemID.ID != ""
+}
+
+/*
+    Номер строки (или диапазон) в новой версии файла, если его нет — в старой
+ */
+func (comment *Comment) GetLine() string {
+    if comment.ToLineRange != "" {
+        return comment.ToLineRange
+    }
+
+    return comment.FromLineRange
+}
+
+type CommentList struct {
+    Comments []Comment `json:"comments"`
+}
+
+/*
+    Обход дерева комментариев: для каждого комментария и ответа вызывается `f`,
+    `parent` равен nil для комментария верхнего уровня
+ */
+func (comments *CommentList) Walk(f func(comment Comment, parent *Comment)) {
+    var walk func(list []Comment, parent *Comment)
+
+    walk = func(list []Comment, parent *Comment) {
+        for i := range list {
+            f(list[i], parent)
+            walk(list[i].Replies, &list[i])
+        }
+    }
+
+    walk(comments.Comments, nil)
+}
+
+func (client *Crucible) GetComments(ctx context.Context, reviewID string) (commentList CommentList, err error) {
+    query := url.Values{}
+    query.Set("render", "false")
+
+    err = client.getJSON(ctx, fmt.Sprintf("/rest-service/reviews-v1/%s/comments", reviewID), query, &commentList)
+    return
+}
+
+/* ReviewItem */
+
+type ReviewItem struct {
+    PermID struct {
+        ID string `json:"id"`
+    } `json:"permId"`
+    RepositoryName string `json:"repositoryName"`
+    FromPath       string `json:"fromPath"`
+    ToPath         string `json:"toPath"`
+}
+
+func (item *ReviewItem) GetID() string {
+    return item.PermID.ID
+}
+
+func (item *ReviewItem) GetPath() string {
+    if item.ToPath != "" {
+        return item.ToPath
+    }
+
+    return item.FromPath
+}
+
+type ReviewItemList struct {
+    ReviewItems []ReviewItem `json:"reviewItem"`
+}
+
+func (items *ReviewItemList) FindById(id string) (item ReviewItem, ok bool) {
+    for _, i := range items.ReviewItems {
+        if i.GetID() == id {
+            return i, true
+        }
+    }
+
+    return
+}
+
+func (client *Crucible) GetReviewItems(ctx context.Context, reviewID string) (itemList ReviewItemList, err error) {
+    err = client.getJSON(ctx, fmt.Sprintf("/rest-service/reviews-v1/%s/reviewitems", reviewID), nil, &itemList)
+    return
+}
@@ -0,0 +1,72 @@
+package crucible
+
+import (
+    "reflect"
+    "testing"
+)
+
+func testReview(reviewers ...Reviewer) Review {
+    var review Review
+
+    review.PermaID.ID = "CR-1"
+    review.Name = "Ревью"
+    review.State = StateReview
+    review.Reviewers.Reviewer = reviewers
+
+    return review
+}
+
+func expectDiffs(t *testing.T, v1 Review, v2 Review, want ...string) {
+    t.Helper()
+
+    equal, diffs := Compare(v1, v2)
+
+    if equal != (len(want) == 0) || !reflect.DeepEqual(diffs, want) {
+        t.Errorf("Compare = %v %q, ожидалось %q", equal, diffs, want)
+    }
+}
+
+func TestCompareEqual(t *testing.T) {
+    review := testReview(Reviewer{UserName: "a"})
+
+    expectDiffs(t, review, review)
+}
+
+/*
+    Ревьюверы сравниваются по имени: другой порядок в ответе Crucible — не изменение
+ */
+func TestCompareReorderedReviewers(t *testing.T) {
+    v1 := testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "b"}, Reviewer{UserName: "c"})
+    v2 := testReview(Reviewer{UserName: "c"}, Reviewer{UserName: "b", Completed: true}, Reviewer{UserName: "a"})
+
+    expectDiffs(t, v1, v2, "reviewers.complited")
+
+    v2 = testReview(Reviewer{UserName: "c"}, Reviewer{UserName: "b"}, Reviewer{UserName: "a"})
+    expectDiffs(t, v1, v2)
+}
+
+func TestCompareReviewersJoinLeave(t *testing.T) {
+    v1 := testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "b"})
+
+    expectDiffs(t, v1, testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "b"}, Reviewer{UserName: "c"}), "reviewers.join")
+    expectDiffs(t, v1, testReview(Reviewer{UserName: "b"}), "reviewers.leave")
+    expectDiffs(t, v1, testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "c", Completed: true}), "reviewers.complited", "reviewers.join", "reviewers.leave")
+
+    // Отмена завершения — не завершение
+    expectDiffs(t, testReview(Reviewer{UserName: "a", Completed: true}), testReview(Reviewer{UserName: "a"}))
+}
+
+func TestCompareFields(t *testing.T) {
+    v1 := testReview()
+    v2 := testReview()
+    v2.Name = "Другое"
+    v2.Description = "Описание"
+    v2.State = StateClosed
+
+    expectDiffs(t, v1, v2, "name", "state", "description")
+
+    v1.State = StateDraft
+    v2 = v1
+    v2.State = StateClosed
+    expectDiffs(t, v1, v2, "state", "state.unexpected")
+}
@@ -0,0 +1,149 @@
+package crucible
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/url"
+    "strings"
+)
+
+type CreateReviewOptions struct {
+    Project string
+    Name string
+    Description string
+    JiraIssueKey string
+    // Автор ревью, если не указан — пользователь бота
+    Author string
+    // Репозиторий Fisheye
+    Repository string
+    // Ревью по changeset'ам
+    Changesets []string
+    // Ревью по ревизиям файла
+    Path string
+    Revisions []string
+}
+
+type userData struct {
+    UserName string `json:"userName"`
+}
+
+type reviewData struct {
+    ProjectKey string `json:"projectKey"`
+    Name string `json:"name"`
+    Description string `json:"description"`
+    Author *userData `json:"author,omitempty"`
+    Type string `json:"type"`
+    AllowReviewersToJoin bool `json:"allowReviewersToJoin"`
+    JiraIssueKey string `json:"jiraIssueKey,omitempty"`
+}
+
+type changesetData struct {
+    ID string `json:"id"`
+}
+
+type changesets struct {
+    ChangesetData []changesetData `json:"changesetData"`
+    Repository string `json:"repository"`
+}
+
+type createReview struct {
+    ReviewData reviewData `json:"reviewData"`
+    Changesets *changesets `json:"changesets,omitempty"`
+}
+
+type revisionData struct {
+    Source string `json:"source"`
+    Path string `json:"path"`
+    Rev []string `json:"rev"`
+}
+
+/*
+    Создаёт ревью в состоянии Draft, для запуска нужно вызвать `StartReview`
+ */
+func (client *Crucible) CreateReview(ctx context.Context, options CreateReviewOptions) (review Review, err error) {
+    if options.Project == "" {
+        err = errors.New("Не указан проект")
+        return
+    }
+
+    if options.Name == "" {
+        err = errors.New("Не указано название ревью")
+        return
+    }
+
+    if options.Repository == "" || (len(options.Changesets) == 0 && options.Path == "") {
+        err = errors.New("Нужно указать репозиторий и changeset или путь к файлу")
+        return
+    }
+
+    request := createReview{
+        ReviewData: reviewData{
+            ProjectKey: options.Project,
+            Name: options.Name,
+            Description: options.Description,
+            Type: "REVIEW",
+            AllowReviewersToJoin: true,
+            JiraIssueKey: options.JiraIssueKey,
+        },
+    }
+
+    if options.Author != "" {
+        request.ReviewData.Author = &userData{UserName: options.Author}
+    }
+
+    if len(options.Changesets) > 0 {
+        request.Changesets = &changesets{Repository: options.Repository}
+
+        for _, id := range options.Changesets {
+            request.Changesets.ChangesetData = append(request.Changesets.ChangesetData, changesetData{ID: id})
+        }
+    }
+
+    err = client.postJSON(ctx, "/rest-service/reviews-v1", nil, request, &review)
+
+    if err != nil {
+        return
+    }
+
+    if options.Path != "" {
+        err = client.AddRevisions(ctx, review.GetID(), options.Repository, options.Path, options.Revisions)
+    }
+
+    return
+}
+
+func (client *Crucible) AddRevisions(ctx context.Context, reviewID string, repository string, path string, revisions []string) (err error) {
+    data := revisionData{
+        Source: repository,
+        Path: path,
+        Rev: revisions,
+    }
+
+    return client.postJSON(ctx, fmt.Sprintf("/rest-service/reviews-v1/%s/revisions", reviewID), nil, data, nil)
+}
+
+func (client *Crucible) AddReviewers(ctx context.Context, reviewID string, userNames []string) (err error) {
+    if len(userNames) == 0 {
+        return
+    }
+
+    path := fmt.Sprintf("/rest-service/reviews-v1/%s/reviewers", reviewID)
+    return client.doJSON(ctx, "POST", path, nil, "text/plain", strings.NewReader(strings.Join(userNames, ",")), nil)
+}
+
+/*
+    Переход ревью в другое состояние, например `action:approveReview`
+ */
+func (client *Crucible) Transition(ctx context.Context, reviewID string, action string) (review Review, err error) {
+    query := url.Values{}
+    query.Set("action", action)
+    query.Set("ignoreWarnings", "true")
+
+    err = client.postJSON(ctx, fmt.Sprintf("/rest-service/reviews-v1/%s/transition", reviewID), query, nil, &review)
+    return
+}
+
+func (client *Crucible) StartReview(ctx context.Context, reviewID string) (review Review, err error) {
+    return client.Transition(ctx, reviewID, "action:approveReview")
+}
@@ -1,6 +1,11 @@
 package crucible
 
 import (
+    "context"
+    "../compare"
+    "../metrics"
+    "bytes"
+    "io"
     "net/url"
     "net/http"
     "fmt"
@@ -8,12 +13,27 @@ import (
     "io/ioutil"
     "encoding/json"
     "errors"
+    "hash/fnv"
     "time"
     "strconv"
-    "reflect"
+    "log"
+    "sort"
 )
 
 
+var tokenRefreshesTotal = metrics.NewCounter(
+    "reviewbot_crucible_token_refreshes_total",
+    "Получение токена авторизации Crucible по результату: ok, error",
+    "result",
+)
+
+var requestDuration = metrics.NewHistogram(
+    "reviewbot_crucible_request_duration_seconds",
+    "Время запросов к REST API Crucible",
+    nil,
+    "method",
+)
+
 type CrucibleToken struct {
     Token string `json:"token"`
 }
@@ -21,7 +41,9 @@ type CrucibleToken struct {
 type Config struct {
     Host string `json:"host"`
     Login string `json:"login"`
-    Password string `json:"password"`
+    Password string `json:"password" secret:"true"`
+    // Файл с паролем, вместо `password`
+    PasswordFile string `json:"passwordFile"`
     Timeout time.Duration `json:"timeout"`
 }
 
@@ -51,7 +73,7 @@ func (client *Crucible) getUrl() url.URL {
 }
 
 
-func (client *Crucible) requestToken() (token CrucibleToken, err error) {
+func (client *Crucible) requestToken(ctx context.Context) (token CrucibleToken, err error) {
     apiUrl := client.getUrl()
     apiUrl.Path = "/rest-service-fecru/auth/login"
 
@@ -59,7 +81,7 @@ func (client *Crucible) requestToken() (token CrucibleToken, err error) {
     form.Add("userName", client.config.Login)
     form.Add("password", client.config.Password)
 
-    req, err := http.NewRequest("POST", apiUrl.String(), strings.NewReader(form.Encode()))
+    req, err := http.NewRequestWithContext(ctx, "POST", apiUrl.String(), strings.NewReader(form.Encode()))
 
     if err != nil {
         return
@@ -86,23 +108,25 @@ func (client *Crucible) requestToken() (token CrucibleToken, err error) {
     return
 }
 
-func (client *Crucible) GetToken() (token string, err error) {
+func (client *Crucible) GetToken(ctx context.Context) (token string, err error) {
     if client.token != "" {
         token = client.token
         return
     }
 
-    tok, err := client.requestToken()
+    tok, err := client.requestToken(ctx)
 
-    if err != nil {
-        return
+    if err == nil && tok.Token == "" {
+        err = errors.New("Не удалось получить токен")
     }
 
-    if tok.Token == "" {
-        err = errors.New("Не удалось получить токен")
+    if err != nil {
+        tokenRefreshesTotal.Inc("error")
         return
     }
 
+    tokenRefreshesTotal.Inc("ok")
+
     client.token = tok.Token
     token = tok.Token
     return
@@ -111,14 +135,28 @@ func (client *Crucible) GetToken() (token string, err error) {
 type GetReviewsOptions struct {
     Project string
     FromDate time.Time
-    States []string
+    ToDate time.Time
+    States []State
+    // Валидаторы прошлого ответа на тот же запрос, для условного запроса
+    Validators Validators
+}
+
+/*
+    Валидаторы ответа со списком ревью. ETag и Last-Modified уходят в If-None-Match
+    и If-Modified-Since, если Crucible их прислал. Хеш тела — для серверов без условных
+    запросов: одинаковый ответ не разбирается заново
+ */
+type Validators struct {
+    ETag string
+    LastModified string
+    Digest uint64
 }
 
-func (client *Crucible) GetReviews(options GetReviewsOptions) (reviewList ReviewList, err error) {
+func (client *Crucible) GetReviews(ctx context.Context, options GetReviewsOptions) (reviewList ReviewList, err error) {
     apiUrl := client.getUrl()
     apiUrl.Path = "/rest-service/reviews-v1/filter/details"
 
-    token, err := client.GetToken()
+    token, err := client.GetToken(ctx)
 
     if err != nil {
         return
@@ -136,23 +174,39 @@ func (client *Crucible) GetReviews(options GetReviewsOptions) (reviewList Review
         query.Set("fromDate", strconv.FormatInt(fromDate, 10))
     }
 
+    if !options.ToDate.IsZero() {
+        toDate := options.ToDate.UnixNano() / int64(time.Millisecond)
+        query.Set("toDate", strconv.FormatInt(toDate, 10))
+    }
+
 
     if len(options.States) > 0 {
-        query.Set("states", strings.Join(options.States, ","))
+        query.Set("states", joinStates(options.States))
     }
 
     apiUrl.RawQuery = query.Encode()
 
-    request, err := http.NewRequest("GET", apiUrl.String(), nil)
-    request.Header.Set("Accept", "application/json")
+    request, err := http.NewRequestWithContext(ctx, "GET", apiUrl.String(), nil)
 
     if err != nil {
         return
     }
 
+    request.Header.Set("Accept", "application/json")
+
+    if options.Validators.ETag != "" {
+        request.Header.Set("If-None-Match", options.Validators.ETag)
+    }
+
+    if options.Validators.LastModified != "" {
+        request.Header.Set("If-Modified-Since", options.Validators.LastModified)
+    }
+
     httpClient := http.Client{}
 
+    started := time.Now()
     response, err := httpClient.Do(request)
+    requestDuration.Observe(time.Since(started).Seconds(), "GET")
 
     if err != nil {
         return
@@ -166,10 +220,154 @@ func (client *Crucible) GetReviews(options GetReviewsOptions) (reviewList Review
         return
     }
 
+    if response.StatusCode == http.StatusNotModified {
+        reviewList.Validators = options.Validators
+        reviewList.NotModified = true
+        return
+    }
+
+    if response.StatusCode >= 300 {
+        err = &APIError{Method: "GET", Path: apiUrl.Path, Status: response.StatusCode, Message: response.Status + " " + string(bytes)}
+        return
+    }
+
+    hash := fnv.New64a()
+    hash.Write(bytes)
+
+    reviewList.Validators = Validators{
+        ETag: response.Header.Get("ETag"),
+        LastModified: response.Header.Get("Last-Modified"),
+        Digest: hash.Sum64(),
+    }
+
+    if options.Validators.Digest != 0 && options.Validators.Digest == reviewList.Validators.Digest {
+        reviewList.NotModified = true
+        return
+    }
+
     err = json.Unmarshal(bytes, &reviewList)
     return
 }
 
+/*
+    Ответ REST API с кодом ошибки
+ */
+type APIError struct {
+    Method string
+    Path string
+    Status int
+    Message string
+}
+
+func (err *APIError) Error() string {
+    return fmt.Sprint("Crucible: ошибка запроса ", err.Method, " ", err.Path, " ", err.Message)
+}
+
+/*
+    Код ответа, если ошибка — ответ REST API, иначе 0
+ */
+func ErrorStatus(err error) int {
+    var apiError *APIError
+
+    if errors.As(err, &apiError) {
+        return apiError.Status
+    }
+
+    return 0
+}
+
+/*
+    Одно ревью со всеми подробностями, как в GetReviews
+ */
+func (client *Crucible) GetReview(ctx context.Context, reviewID string) (review Review, err error) {
+    err = client.getJSON(ctx, fmt.Sprintf("/rest-service/reviews-v1/%s/details", url.PathEscape(reviewID)), nil, &review)
+    return
+}
+
+/*
+    GET запрос к REST API Crucible с авторизацией через FEAUTH, ответ разбирается в `result`
+ */
+func (client *Crucible) getJSON(ctx context.Context, path string, query url.Values, result interface{}) (err error) {
+    return client.doJSON(ctx, "GET", path, query, "", nil, result)
+}
+
+/*
+    POST запрос с JSON телом `data`, ответ (если `result` не nil) разбирается в `result`
+ */
+func (client *Crucible) postJSON(ctx context.Context, path string, query url.Values, data interface{}, result interface{}) (err error) {
+    var body io.Reader
+
+    if data != nil {
+        payload, err := json.Marshal(data)
+
+        if err != nil {
+            return err
+        }
+
+        body = bytes.NewReader(payload)
+    }
+
+    return client.doJSON(ctx, "POST", path, query, "application/json", body, result)
+}
+
+func (client *Crucible) doJSON(ctx context.Context, method string, path string, query url.Values, contentType string, body io.Reader, result interface{}) (err error) {
+    apiUrl := client.getUrl()
+    apiUrl.Path = path
+
+    token, err := client.GetToken(ctx)
+
+    if err != nil {
+        return
+    }
+
+    if query == nil {
+        query = url.Values{}
+    }
+
+    query.Set("FEAUTH", token)
+    apiUrl.RawQuery = query.Encode()
+
+    request, err := http.NewRequestWithContext(ctx, method, apiUrl.String(), body)
+
+    if err != nil {
+        return
+    }
+
+    request.Header.Set("Accept", "application/json")
+
+    if contentType != "" {
+        request.Header.Set("Content-Type", contentType)
+    }
+
+    started := time.Now()
+    response, err := client.httpClient.Do(request)
+    requestDuration.Observe(time.Since(started).Seconds(), method)
+
+    if err != nil {
+        return
+    }
+
+    defer response.Body.Close()
+
+    responseBytes, err := ioutil.ReadAll(response.Body)
+
+    if err != nil {
+        return
+    }
+
+    if response.StatusCode >= 300 {
+        err = &APIError{Method: method, Path: path, Status: response.StatusCode, Message: response.Status + " " + string(responseBytes)}
+        return
+    }
+
+    if result == nil || len(responseBytes) == 0 {
+        return
+    }
+
+    err = json.Unmarshal(responseBytes, result)
+    return
+}
+
 /* Review */
 
 type Review struct {
@@ -179,7 +377,8 @@ type Review struct {
                 URL         string `json:"url"`
                 UserName    string `json:"userName"`
             } `json:"author"`
-    CreateDate string `json:"createDate"`
+    CreateDate Timestamp `json:"createDate"`
+    CloseDate  Timestamp `json:"closeDate"`
     Creator    struct {
                 AvatarURL   string `json:"avatarUrl"`
                 DisplayName string `json:"displayName"`
@@ -199,60 +398,109 @@ type Review struct {
     PermaIDHistory []string `json:"permaIdHistory"`
     ProjectKey     string   `json:"projectKey"`
     Reviewers      struct {
-                Reviewer []struct {
-                    AvatarURL                  string `json:"avatarUrl"`
-                    Completed                  bool   `json:"completed"`
-                    CompletionStatusChangeDate int    `json:"completionStatusChangeDate"`
-                    DisplayName                string `json:"displayName"`
-//                    TimeSpent                  int    `json:"timeSpent"`
-                    UserName                   string `json:"userName"`
-                } `json:"reviewer"`
+                Reviewer []Reviewer `json:"reviewer"`
             } `json:"reviewers"`
-    State string `json:"state"`
+    State State `json:"state"`
     Type string `json:"type"`
 }
 
+type Reviewer struct {
+    AvatarURL                  string    `json:"avatarUrl"`
+    Completed                  bool      `json:"completed"`
+    CompletionStatusChangeDate Timestamp `json:"completionStatusChangeDate"`
+    DisplayName                string    `json:"displayName"`
+//    TimeSpent                  int       `json:"timeSpent"`
+    UserName                   string    `json:"userName"`
+}
+
+// Ревьюверы сопоставляются по имени, а не по порядку в списке
+var reviewComparer = compare.Comparer{
+    SliceKeys: map[string]string{"Reviewers.Reviewer": "UserName"},
+}
+
+/*
+    Изменения ревью: name, state, state.unexpected, description, reviewers.complited,
+    reviewers.join, reviewers.leave
+ */
 func Compare(v1 Review, v2 Review) (equal bool, diffs []string) {
+    _, differences, err := reviewComparer.Compare(v1, v2)
 
-    if reflect.DeepEqual(v1, v2) {
-        return equal, diffs
+    if err != nil {
+        log.Println("Ошибка сравнения ревью", v2.GetID(), err)
+        return
     }
 
-    if v1.Name != v2.Name {
+    if compare.Changed(differences, "Name") {
         diffs = append(diffs, "name")
     }
 
-    if v1.State != v2.State {
+    if compare.Changed(differences, "State") {
         diffs = append(diffs, "state")
+
+        if !v1.State.CanTransition(v2.State) {
+            diffs = append(diffs, "state.unexpected")
+        }
     }
 
-    if v1.Description != v2.Description {
+    if compare.Changed(differences, "Description") {
         diffs = append(diffs, "description")
     }
 
-    if !reflect.DeepEqual(v1.Reviewers, v2.Reviewers) {
-        v1Completed := v1.GetCountCompleted()
-        v2Completed := v2.GetCountCompleted()
+    completed, joined, left := false, false, false
 
-        if v1Completed < v2Completed {
-            diffs = append(diffs, "reviewers.complited")
+    for _, difference := range differences {
+        if !strings.HasPrefix(difference.FieldName, "Reviewers.Reviewer[") {
+            continue
         }
 
-        if len(v1.Reviewers.Reviewer) < len(v2.Reviewers.Reviewer) {
-            diffs = append(diffs, "reviewers.join")
+        switch {
+        case difference.Value1 == nil:
+            joined = true
+            reviewer, _ := difference.Value2.(Reviewer)
+            completed = completed || reviewer.Completed
+        case difference.Value2 == nil:
+            left = true
+        case strings.HasSuffix(difference.FieldName, "].Completed"):
+            completed = completed || difference.Value2 == true
         }
+    }
 
-        if len(v1.Reviewers.Reviewer) > len(v2.Reviewers.Reviewer) {
-            diffs = append(diffs, "reviewers.leave")
-        }
+    if completed {
+        diffs = append(diffs, "reviewers.complited")
+    }
+
+    if joined {
+        diffs = append(diffs, "reviewers.join")
+    }
+
+    if left {
+        diffs = append(diffs, "reviewers.leave")
     }
 
     equal = len(diffs) == 0
     return equal, diffs
 }
 
+// Сколько ревьюверов должны завершить ревью, чтобы оно считалось завершённым
+const CompletedReviewersRequired = 2
+
 func (review *Review) IsCompleted() bool {
-    return review.GetCountCompleted() >= 2
+    return review.IsCompletedBy(CompletedReviewersRequired)
+}
+
+/*
+    Завершено ли ревью `required` ревьюверами, при отрицательном `required` — всеми ревьюверами
+ */
+func (review *Review) IsCompletedBy(required int) bool {
+    if required < 0 {
+        required = len(review.Reviewers.Reviewer)
+
+        if required == 0 {
+            return false
+        }
+    }
+
+    return review.GetCountCompleted() >= required
 }
 
 
@@ -274,13 +522,21 @@ func (review *Review) GetCountCompleted() int {
 }
 
 
-func (review *Review) GetState() string {
+func (review *Review) GetState() State {
     return review.State
 }
 
 
 func (review *Review) IsOpen() bool {
-    return review.GetState() == "Review"
+    return review.GetState() == StateReview
+}
+
+
+/*
+    Переход из состояния `from` в текущее состояние ревью не предусмотрен в Crucible
+ */
+func (review *Review) IsUnexpectedTransition(from State) bool {
+    return !from.CanTransition(review.GetState()) || !review.GetState().IsKnown()
 }
 
 
@@ -308,10 +564,121 @@ func (review *Review) GetReviewersNames() (names []string) {
 }
 
 
+func (review *Review) FindReviewer(userName string) (reviewer Reviewer, ok bool) {
+    for _, reviewer := range review.Reviewers.Reviewer {
+        if reviewer.UserName == userName {
+            return reviewer, true
+        }
+    }
+
+    return
+}
+
+
+/*
+    Сколько прошло с создания ревью, 0 если дата создания неизвестна
+ */
+func (review *Review) Age() time.Duration {
+    if review.CreateDate.IsZero() {
+        return 0
+    }
+
+    return time.Since(review.CreateDate.Time)
+}
+
+
+/*
+    Через сколько после создания ревью его завершил первый ревьювер
+ */
+func (review *Review) TimeToFirstCompletion() (duration time.Duration, ok bool) {
+    for _, reviewer := range review.Reviewers.Reviewer {
+        latency, completed := review.ReviewerLatency(reviewer.UserName)
+
+        if completed && (!ok || latency < duration) {
+            duration, ok = latency, true
+        }
+    }
+
+    return
+}
+
+
+/*
+    Через сколько после создания ревью его завершил ревьювер `userName`,
+    `ok` равен false, если ревьювер ещё не завершил ревью или даты неизвестны
+ */
+func (review *Review) ReviewerLatency(userName string) (latency time.Duration, ok bool) {
+    reviewer, found := review.FindReviewer(userName)
+
+    if !found || !reviewer.Completed || reviewer.CompletionStatusChangeDate.IsZero() || review.CreateDate.IsZero() {
+        return
+    }
+
+    latency = reviewer.CompletionStatusChangeDate.Sub(review.CreateDate.Time)
+
+    if latency < 0 {
+        latency = 0
+    }
+
+    return latency, true
+}
+
+
+/*
+    Когда ревью стало завершённым: время, когда его завершил последний из `required` ревьюверов,
+    при отрицательном `required` — последний из всех ревьюверов
+ */
+func (review *Review) CompletionTime(required int) (completion time.Time, ok bool) {
+    if !review.IsCompletedBy(required) {
+        return
+    }
+
+    if required < 0 {
+        required = len(review.Reviewers.Reviewer)
+    }
+
+    times := []time.Time{}
+
+    for _, reviewer := range review.Reviewers.Reviewer {
+        if reviewer.Completed && !reviewer.CompletionStatusChangeDate.IsZero() {
+            times = append(times, reviewer.CompletionStatusChangeDate.Time)
+        }
+    }
+
+    if required == 0 || len(times) < required {
+        return
+    }
+
+    sort.Slice(times, func(i, j int) bool {
+        return times[i].Before(times[j])
+    })
+
+    return times[required-1], true
+}
+
+
+/*
+    Ревьюверы, которые ещё не завершили ревью
+ */
+func (review *Review) GetPendingReviewersNames() (names []string) {
+    for _, reviewer := range review.Reviewers.Reviewer {
+        if !reviewer.Completed {
+            names = append(names, reviewer.UserName)
+        }
+    }
+
+    return
+}
+
+
 /* ReviewList */
 
 type ReviewList struct {
     Reviews []Review `json:"detailedReviewData"`
+    // Для следующего условного запроса
+    Validators Validators `json:"-"`
+    // Список не изменился с прошлого запроса, Reviews пустой
+    NotModified bool `json:"-"`
 }
 
 func (reviews *ReviewList) Filter(f func(Review) bool) []Review {
@@ -325,21 +692,3 @@ func (reviews *ReviewList) Filter(f func(Review) bool) []Review {
 
     return resultList;
 }
-
-func (reviews *ReviewList) FindById(id string) (review Review, err error) {
-    arr := reviews.Filter(func(item Review) bool {
-        if(item.GetID() == id){
-            return true
-        } else {
-            return false;
-        }
-    })
-
-    if len(arr) > 0 {
-        review = arr[0]
-    } else {
-        err = errors.New("Not found")
-    }
-
-    return
-}
\ No newline at end of file
@@ -0,0 +1,47 @@
+package crucible
+
+import (
+    "testing"
+    "time"
+)
+
+func completedAt(userName string, minutes int) Reviewer {
+    reviewer := Reviewer{UserName: userName, Completed: minutes > 0}
+
+    if minutes > 0 {
+        reviewer.CompletionStatusChangeDate.Time = time.Date(2024, 1, 1, 12, minutes, 0, 0, time.UTC)
+    }
+
+    return reviewer
+}
+
+/*
+    Время завершения зависит от числа ревьюверов, нужного проекту
+ */
+func TestCompletionTime(t *testing.T) {
+    review := testReview(completedAt("a", 10), completedAt("b", 5), completedAt("c", 0))
+
+    for _, test := range []struct {
+        required int
+        minute int
+        ok bool
+    }{
+        {1, 5, true},
+        {2, 10, true},
+        {3, 0, false},
+        // Все ревьюверы
+        {-1, 0, false},
+    } {
+        completion, ok := review.CompletionTime(test.required)
+
+        if ok != test.ok || ok && completion.Minute() != test.minute {
+            t.Errorf("CompletionTime(%d) = %v %v, ожидалось %d мин %v", test.required, completion, ok, test.minute, test.ok)
+        }
+    }
+
+    review.Reviewers.Reviewer[2] = completedAt("c", 20)
+
+    if completion, ok := review.CompletionTime(-1); !ok || completion.Minute() != 20 {
+        t.Errorf("CompletionTime(-1) = %v %v, ожидалось завершение последним ревьювером", completion, ok)
+    }
+}
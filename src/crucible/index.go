@@ -0,0 +1,147 @@
+package crucible
+
+import (
+    "hash/fnv"
+    "sort"
+    "strconv"
+)
+
+/*
+    Отпечаток ревью: хеш полей, изменение которых нам интересно. Считается один раз
+    при получении списка, сравнение ревью — сравнение двух чисел
+ */
+func (review *Review) Fingerprint() uint64 {
+    hash := fnv.New64a()
+
+    write := func(values ...string) {
+        for _, value := range values {
+            hash.Write([]byte(value))
+            hash.Write([]byte{0})
+        }
+    }
+
+    write(
+        review.GetID(),
+        review.Name,
+        review.Description,
+        string(review.State),
+        review.Type,
+        review.ProjectKey,
+        review.JiraIssueKey,
+        review.Author.UserName,
+        review.Creator.UserName,
+        strconv.FormatInt(review.CreateDate.UnixNano(), 10),
+        strconv.FormatInt(review.CloseDate.UnixNano(), 10),
+        strconv.Itoa(len(review.GeneralComments.Comments)),
+    )
+
+    // Порядок ревьюверов в ответе Crucible не важен, Compare тоже сопоставляет их по имени
+    reviewers := append([]Reviewer{}, review.Reviewers.Reviewer...)
+
+    sort.Slice(reviewers, func(i, j int) bool {
+        if reviewers[i].UserName != reviewers[j].UserName {
+            return reviewers[i].UserName < reviewers[j].UserName
+        }
+
+        if reviewers[i].Completed != reviewers[j].Completed {
+            return !reviewers[i].Completed
+        }
+
+        return reviewers[i].CompletionStatusChangeDate.Before(reviewers[j].CompletionStatusChangeDate.Time)
+    })
+
+    for _, reviewer := range reviewers {
+        write(
+            reviewer.UserName,
+            strconv.FormatBool(reviewer.Completed),
+            strconv.FormatInt(reviewer.CompletionStatusChangeDate.UnixNano(), 10),
+        )
+    }
+
+    return hash.Sum64()
+}
+
+type indexedReview struct {
+    review Review
+    fingerprint uint64
+}
+
+/*
+    Ревью по PermaID вместе с отпечатками
+ */
+type ReviewIndex struct {
+    reviews map[string]indexedReview
+}
+
+func NewReviewIndex(reviews []Review) ReviewIndex {
+    index := ReviewIndex{reviews: make(map[string]indexedReview, len(reviews))}
+
+    for _, review := range reviews {
+        index.Set(review)
+    }
+
+    return index
+}
+
+func (index *ReviewIndex) Get(id string) (review Review, ok bool) {
+    indexed, ok := index.reviews[id]
+    return indexed.review, ok
+}
+
+/*
+    Отличается ли ревью от известного, новое ревью всегда отличается
+ */
+func (index *ReviewIndex) Changed(review Review) bool {
+    indexed, ok := index.reviews[review.GetID()]
+    return !ok || indexed.fingerprint != review.Fingerprint()
+}
+
+func (index *ReviewIndex) Set(review Review) {
+    if index.reviews == nil {
+        index.reviews = map[string]indexedReview{}
+    }
+
+    index.reviews[review.GetID()] = indexedReview{review, review.Fingerprint()}
+}
+
+func (index *ReviewIndex) Remove(id string) {
+    delete(index.reviews, id)
+}
+
+/*
+    Все ревью индекса по порядку PermaID
+ */
+func (index *ReviewIndex) Reviews() []Review {
+    ids := make([]string, 0, len(index.reviews))
+
+    for id := range index.reviews {
+        ids = append(ids, id)
+    }
+
+    sort.Strings(ids)
+
+    reviews := make([]Review, 0, len(ids))
+
+    for _, id := range ids {
+        reviews = append(reviews, index.reviews[id].review)
+    }
+
+    return reviews
+}
+
+func (index *ReviewIndex) Len() int {
+    return len(index.reviews)
+}
+
+/*
+    Ревью из индекса, которых нет в `current`
+ */
+func (index *ReviewIndex) Missing(current ReviewIndex) (missing []Review) {
+    for id, indexed := range index.reviews {
+        if _, ok := current.reviews[id]; !ok {
+            missing = append(missing, indexed.review)
+        }
+    }
+
+    return
+}
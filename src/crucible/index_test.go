@@ -0,0 +1,27 @@
+package crucible
+
+import (
+    "testing"
+)
+
+func TestFingerprintReviewerOrder(t *testing.T) {
+    v1 := testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "b", Completed: true}, Reviewer{UserName: "c"})
+    v2 := testReview(Reviewer{UserName: "c"}, Reviewer{UserName: "a"}, Reviewer{UserName: "b", Completed: true})
+
+    if v1.Fingerprint() != v2.Fingerprint() {
+        t.Error("отпечаток зависит от порядка ревьюверов")
+    }
+
+    v2.Reviewers.Reviewer[0].Completed = true
+
+    if v1.Fingerprint() == v2.Fingerprint() {
+        t.Error("отпечаток не изменился после завершения ревьювером")
+    }
+
+    // Состав ревьюверов тоже часть отпечатка
+    v2 = testReview(Reviewer{UserName: "a"}, Reviewer{UserName: "b", Completed: true})
+
+    if v1.Fingerprint() == v2.Fingerprint() {
+        t.Error("отпечаток не изменился после ухода ревьювера")
+    }
+}
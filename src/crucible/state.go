@@ -0,0 +1,112 @@
+package crucible
+
+import (
+    "errors"
+    "fmt"
+    "strings"
+)
+
+// https://docs.atlassian.com/fisheye-crucible/latest/wadl/crucible.html#d2e160
+
+type State string
+
+const (
+    StateDraft     State = "Draft"
+    StateApproval  State = "Approval"
+    StateReview    State = "Review"
+    StateSummarize State = "Summarize"
+    StateClosed    State = "Closed"
+    StateDead      State = "Dead"
+    StateRejected  State = "Rejected"
+    StateUnknown   State = "Unknown"
+)
+
+var States = []State{
+    StateDraft,
+    StateApproval,
+    StateReview,
+    StateSummarize,
+    StateClosed,
+    StateDead,
+    StateRejected,
+    StateUnknown,
+}
+
+/*
+    Допустимые переходы между состояниями ревью
+ */
+var stateTransitions = map[State][]State{
+    StateDraft:     {StateApproval, StateReview, StateDead},
+    StateApproval:  {StateReview, StateRejected, StateDraft, StateDead},
+    StateReview:    {StateSummarize, StateClosed, StateDraft, StateDead},
+    StateSummarize: {StateClosed, StateReview, StateDead},
+    StateClosed:    {StateReview, StateDead},
+    StateDead:      {StateDraft, StateReview},
+    StateRejected:  {StateDraft, StateApproval, StateDead},
+}
+
+func ParseState(value string) (state State, err error) {
+    for _, s := range States {
+        if strings.EqualFold(string(s), strings.TrimSpace(value)) {
+            return s, nil
+        }
+    }
+
+    return StateUnknown, errors.New(fmt.Sprint("Неизвестное состояние ревью ", value))
+}
+
+func (state State) String() string {
+    return string(state)
+}
+
+func (state State) IsKnown() bool {
+    for _, s := range States {
+        if s == state && s != StateUnknown {
+            return true
+        }
+    }
+
+    return false
+}
+
+/*
+    Ревью завершено и больше не изменится без повторного открытия
+ */
+func (state State) IsTerminal() bool {
+    return state == StateClosed || state == StateDead || state == StateRejected
+}
+
+/*
+    Ревью в работе у участников
+ */
+func (state State) IsActive() bool {
+    return state == StateApproval || state == StateReview || state == StateSummarize
+}
+
+/*
+    Возможен ли переход из `state` в `to`. Пустое состояние означает, что ревью раньше не встречалось,
+    из него и из Unknown возможен переход в любое состояние.
+ */
+func (state State) CanTransition(to State) bool {
+    if state == to || state == "" || state == StateUnknown {
+        return true
+    }
+
+    for _, s := range stateTransitions[state] {
+        if s == to {
+            return true
+        }
+    }
+
+    return false
+}
+
+func joinStates(states []State) string {
+    values := make([]string, 0, len(states))
+
+    for _, state := range states {
+        values = append(values, string(state))
+    }
+
+    return strings.Join(values, ",")
+}
@@ -0,0 +1,91 @@
+package crucible
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "time"
+)
+
+// Форматы дат в ответах Crucible, например 2016-03-22T12:34:56.789+0300
+var timeLayouts = []string{
+    "2006-01-02T15:04:05.000-0700",
+    "2006-01-02T15:04:05-0700",
+    time.RFC3339Nano,
+    time.RFC3339,
+}
+
+/*
+    Время из ответа Crucible: строка в ISO формате или количество миллисекунд с начала эпохи
+ */
+type Timestamp struct {
+    time.Time
+}
+
+func ParseTime(value string) (t time.Time, err error) {
+    for _, layout := range timeLayouts {
+        t, err = time.Parse(layout, value)
+
+        if err == nil {
+            return
+        }
+    }
+
+    err = errors.New(fmt.Sprint("Неизвестный формат даты ", value))
+    return
+}
+
+func (timestamp *Timestamp) UnmarshalJSON(data []byte) (err error) {
+    data = bytes.TrimSpace(data)
+
+    if len(data) == 0 || string(data) == "null" || string(data) == `""` {
+        timestamp.Time = time.Time{}
+        return
+    }
+
+    if data[0] == '"' {
+        var value string
+
+        err = json.Unmarshal(data, &value)
+
+        if err != nil {
+            return
+        }
+
+        // Миллисекунды иногда приходят строкой
+        if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+            timestamp.Time = fromMillis(millis)
+            return nil
+        }
+
+        timestamp.Time, err = ParseTime(value)
+        return
+    }
+
+    millis, err := strconv.ParseInt(string(data), 10, 64)
+
+    if err != nil {
+        return
+    }
+
+    timestamp.Time = fromMillis(millis)
+    return
+}
+
+func (timestamp Timestamp) MarshalJSON() ([]byte, error) {
+    if timestamp.IsZero() {
+        return []byte("null"), nil
+    }
+
+    return json.Marshal(timestamp.Format(timeLayouts[0]))
+}
+
+func fromMillis(millis int64) time.Time {
+    if millis == 0 {
+        return time.Time{}
+    }
+
+    return time.Unix(0, millis*int64(time.Millisecond))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+    "./crucible"
+    "./schedule"
+    "./slack"
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+)
+
+type DigestConfig struct {
+    Channel string `json:"channel"`
+    // Проекты дайджеста, если не указаны — все проекты, у которых в projectMap указан `channel`
+    Projects []string `json:"projects"`
+    // Расписание в формате cron, например "0 10 * * 1-5" — по будням в 10:00
+    Schedule string `json:"schedule"`
+    Timezone string `json:"timezone"`
+}
+
+func (config *DigestConfig) GetProjects() []string {
+    if len(config.Projects) > 0 {
+        return config.Projects
+    }
+
+    projects := []string{}
+
+    for projectName, project := range CONFIG.Get().ProjectMap {
+        if project.Channel == config.Channel {
+            projects = append(projects, projectName)
+        }
+    }
+
+    sort.Strings(projects)
+    return projects
+}
+
+func (config *DigestConfig) Location() *time.Location {
+    if config.Timezone == "" {
+        return time.Local
+    }
+
+    location, err := time.LoadLocation(config.Timezone)
+
+    if err != nil {
+        log.Println("Неизвестная временная зона", config.Timezone, err)
+        return time.Local
+    }
+
+    return location
+}
+
+/*
+    Группа ревью в дайджесте
+ */
+type digestGroup struct {
+    title string
+    color string
+    reviews []crucible.Review
+}
+
+/*
+    Отправляет дайджест открытых ревью в канал по расписанию
+ */
+func watchDigest(ctx context.Context, crucibleClient *crucible.Crucible, slackClient slack.SlackClient, config DigestConfig, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    cron, err := schedule.Parse(config.Schedule)
+
+    if err != nil {
+        log.Println("Дайджест для канала не будет отправляться", config.Channel, err)
+        return
+    }
+
+    location := config.Location()
+    log.Println("Дайджест для канала", config.Channel, "по расписанию", cron, config.GetProjects())
+
+    for {
+        next := cron.Next(time.Now().In(location))
+
+        if next.IsZero() {
+            log.Println("Расписание дайджеста никогда не срабатывает", config.Channel, cron)
+            return
+        }
+
+        if !sleepContext(ctx, time.Until(next)) {
+            return
+        }
+
+        err := postDigest(ctx, crucibleClient, slackClient, config)
+
+        if err != nil {
+            log.Println("Ошибка отправки дайджеста", config.Channel, err)
+        }
+    }
+}
+
+func postDigest(ctx context.Context, crucibleClient *crucible.Crucible, slackClient slack.SlackClient, config DigestConfig) (err error) {
+    groups := []*digestGroup{
+        {title: "Ждут ревьюверов", color: "danger"},
+        {title: "Частично просмотрены", color: "warning"},
+        {title: "Можно закрывать", color: "good"},
+    }
+
+    for _, projectName := range config.GetProjects() {
+        reviews, err := crucibleClient.GetReviews(ctx, crucible.GetReviewsOptions{
+            Project: projectName,
+            States: []crucible.State{crucible.StateReview},
+        })
+
+        if err != nil {
+            return err
+        }
+
+        project := CONFIG.Get().Project(projectName)
+
+        for _, review := range reviews.Reviews {
+            switch {
+            case project.IsCompleted(review):
+                groups[2].reviews = append(groups[2].reviews, review)
+            case review.GetCountCompleted() > 0:
+                groups[1].reviews = append(groups[1].reviews, review)
+            default:
+                groups[0].reviews = append(groups[0].reviews, review)
+            }
+        }
+    }
+
+    message := slack.Message{
+        Text: "Открытые ревью на сегодня",
+        Channel: config.Channel,
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    for _, group := range groups {
+        // Сначала самые старые
+        sort.Slice(group.reviews, func(i, j int) bool {
+            return group.reviews[i].CreateDate.Before(group.reviews[j].CreateDate.Time)
+        })
+
+        for i, review := range group.reviews {
+            attachment := reviewAttachment(NotifierSlack, review)
+            attachment.Color = group.color
+
+            if i == 0 {
+                attachment.Pretext = fmt.Sprintf("*%s* (%d)", group.title, len(group.reviews))
+            }
+
+            message.AddAttachment(attachment)
+        }
+    }
+
+    if len(message.Attachments) == 0 {
+        message.Text = "Все ревью закрыты"
+    }
+
+    _, err = slackClient.PostMessage(ctx, message)
+    return
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+    "./crucible"
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+)
+
+// Формат дат в выгрузке
+const exportTimeLayout = time.RFC3339
+
+type ExportReviewer struct {
+    UserName string `json:"userName"`
+    Completed bool `json:"completed"`
+    CompletedAt string `json:"completedAt,omitempty"`
+}
+
+/*
+    Строка выгрузки: ревью в плоском виде, пригодном для таблиц
+ */
+type ExportRow struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+    Project string `json:"project"`
+    Author string `json:"author"`
+    State string `json:"state"`
+    JiraIssueKey string `json:"jiraIssueKey"`
+    CreatedAt string `json:"createdAt"`
+    ClosedAt string `json:"closedAt,omitempty"`
+    CompletedAt string `json:"completedAt,omitempty"`
+    Reviewers []ExportReviewer `json:"reviewers"`
+}
+
+var exportColumns = []string{
+    "id", "name", "project", "author", "state", "jira_key",
+    "created_at", "closed_at", "completed_at", "reviewers", "completed_reviewers", "reviewer_completions",
+}
+
+func formatExportTime(t time.Time) string {
+    if t.IsZero() {
+        return ""
+    }
+
+    return t.Format(exportTimeLayout)
+}
+
+func NewExportRow(review crucible.Review, completedReviewers int) ExportRow {
+    row := ExportRow{
+        ID: review.GetID(),
+        Name: review.Name,
+        Project: review.ProjectKey,
+        Author: review.GetAuthorNick(),
+        State: review.GetState().String(),
+        JiraIssueKey: review.JiraIssueKey,
+        CreatedAt: formatExportTime(review.CreateDate.Time),
+        ClosedAt: formatExportTime(review.CloseDate.Time),
+        Reviewers: []ExportReviewer{},
+    }
+
+    if completion, ok := review.CompletionTime(completedReviewers); ok {
+        row.CompletedAt = formatExportTime(completion)
+    }
+
+    for _, reviewer := range review.Reviewers.Reviewer {
+        exported := ExportReviewer{UserName: reviewer.UserName, Completed: reviewer.Completed}
+
+        if reviewer.Completed {
+            exported.CompletedAt = formatExportTime(reviewer.CompletionStatusChangeDate.Time)
+        }
+
+        row.Reviewers = append(row.Reviewers, exported)
+    }
+
+    return row
+}
+
+func (row *ExportRow) CSVRecord() []string {
+    reviewers, completed, completions := []string{}, []string{}, []string{}
+
+    for _, reviewer := range row.Reviewers {
+        reviewers = append(reviewers, reviewer.UserName)
+
+        if reviewer.Completed {
+            completed = append(completed, reviewer.UserName)
+            completions = append(completions, fmt.Sprintf("%s=%s", reviewer.UserName, reviewer.CompletedAt))
+        }
+    }
+
+    return []string{
+        row.ID, row.Name, row.Project, row.Author, row.State, row.JiraIssueKey,
+        row.CreatedAt, row.ClosedAt, row.CompletedAt,
+        strings.Join(reviewers, ";"), strings.Join(completed, ";"), strings.Join(completions, ";"),
+    }
+}
+
+func writeExport(writer io.Writer, format string, rows []ExportRow) (err error) {
+    switch format {
+    case "csv":
+        csvWriter := csv.NewWriter(writer)
+        err = csvWriter.Write(exportColumns)
+
+        if err != nil {
+            return
+        }
+
+        for _, row := range rows {
+            err = csvWriter.Write(row.CSVRecord())
+
+            if err != nil {
+                return
+            }
+        }
+
+        csvWriter.Flush()
+        return csvWriter.Error()
+    case "json":
+        encoder := json.NewEncoder(writer)
+        encoder.SetIndent("", "  ")
+        return encoder.Encode(rows)
+    case "ndjson":
+        encoder := json.NewEncoder(writer)
+
+        for _, row := range rows {
+            err = encoder.Encode(row)
+
+            if err != nil {
+                return
+            }
+        }
+
+        return
+    }
+
+    return errors.New(fmt.Sprint("Неизвестный формат выгрузки ", format))
+}
+
+// Форматы выгрузки для --format
+var exportFormats = []string{"csv", "json", "ndjson"}
+
+/*
+    Дата из аргумента: 2006-01-02 или RFC3339. Для `endOfDay` дата без времени
+    означает конец дня, чтобы --to включал весь последний день
+ */
+func parseExportDate(value string, endOfDay bool) (t time.Time, err error) {
+    if value == "" {
+        return
+    }
+
+    t, err = time.ParseInLocation("2006-01-02", value, time.Local)
+
+    if err == nil {
+        if endOfDay {
+            t = t.AddDate(0, 0, 1).Add(-time.Millisecond)
+        }
+        return
+    }
+
+    return time.Parse(time.RFC3339, value)
+}
+
+/*
+    review_bot export — выгрузка ревью из Crucible в csv, json или ndjson
+ */
+func runExport(args []string) (err error) {
+    flags := flag.NewFlagSet("export", flag.ContinueOnError)
+    project := flags.String("project", "", "проект Crucible, по умолчанию все")
+    from := flags.String("from", "", "с даты, 2006-01-02 или RFC3339")
+    to := flags.String("to", "", "по дату включительно, 2006-01-02 или RFC3339")
+    states := flags.String("states", "", "состояния через запятую, например Review,Closed")
+    format := flags.String("format", "csv", "формат: csv, json или ndjson")
+    output := flags.String("output", "", "файл для выгрузки, по умолчанию stdout")
+
+    err = flags.Parse(args)
+
+    if err == flag.ErrHelp {
+        return nil
+    }
+
+    if err != nil {
+        return
+    }
+
+    if !containsString(exportFormats, *format) {
+        return errors.New(fmt.Sprint("Неизвестный формат выгрузки ", *format, ", возможны: ", strings.Join(exportFormats, ", ")))
+    }
+
+    options := crucible.GetReviewsOptions{Project: *project}
+
+    options.FromDate, err = parseExportDate(*from, false)
+
+    if err != nil {
+        return errors.New(fmt.Sprint("Неверная дата --from: ", err))
+    }
+
+    options.ToDate, err = parseExportDate(*to, true)
+
+    if err != nil {
+        return errors.New(fmt.Sprint("Неверная дата --to: ", err))
+    }
+
+    for _, value := range splitList(*states) {
+        state, err := crucible.ParseState(value)
+
+        if err != nil {
+            return err
+        }
+
+        options.States = append(options.States, state)
+    }
+
+    config, err := getCrucibleConfig(configPath)
+
+    if err != nil {
+        return
+    }
+
+    CONFIG.Set(config)
+
+    crucibleClient, err := crucible.CreateClient(CONFIG.Get().Crucible)
+
+    if err != nil {
+        return
+    }
+
+    reviews, err := crucibleClient.GetReviews(context.Background(), options)
+
+    if err != nil {
+        return
+    }
+
+    rows := make([]ExportRow, 0, len(reviews.Reviews))
+
+    for _, review := range reviews.Reviews {
+        rows = append(rows, NewExportRow(review, CONFIG.Get().Project(review.ProjectKey).CompletedReviewers))
+    }
+
+    writer := io.Writer(os.Stdout)
+
+    if *output != "" {
+        file, err := os.Create(*output)
+
+        if err != nil {
+            return err
+        }
+
+        defer file.Close()
+        writer = file
+    }
+
+    return writeExport(writer, *format, rows)
+}
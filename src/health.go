@@ -0,0 +1,227 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+type ComponentStatus struct {
+    OK bool `json:"ok"`
+    Message string `json:"message,omitempty"`
+    Updated time.Time `json:"updated"`
+}
+
+type ProjectStatus struct {
+    LastPoll time.Time `json:"lastPoll"`
+    // Запланированный опрос с учётом вызовов из Crucible, ошибок, тихих часов и разброса
+    NextPoll time.Time `json:"nextPoll"`
+    LastSuccess time.Time `json:"lastSuccess"`
+    LastError string `json:"lastError,omitempty"`
+    Reviews int `json:"reviews"`
+}
+
+/*
+    Состояние компонентов бота для /healthz, /readyz и /status
+ */
+type Health struct {
+    mutex sync.Mutex
+    started time.Time
+    components map[string]ComponentStatus
+    projects map[string]*ProjectStatus
+    queues map[string]func() int
+}
+
+var health = newHealth()
+
+func newHealth() *Health {
+    return &Health{
+        started: time.Now(),
+        components: map[string]ComponentStatus{},
+        projects: map[string]*ProjectStatus{},
+        queues: map[string]func() int{},
+    }
+}
+
+func (h *Health) SetComponent(name string, err error) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    status := ComponentStatus{OK: err == nil, Updated: time.Now()}
+
+    if err != nil {
+        status.Message = err.Error()
+    }
+
+    h.components[name] = status
+}
+
+func (h *Health) project(projectName string) *ProjectStatus {
+    status, ok := h.projects[projectName]
+
+    if !ok {
+        status = &ProjectStatus{}
+        h.projects[projectName] = status
+    }
+
+    return status
+}
+
+/*
+    Результат опроса Crucible по проекту
+ */
+func (h *Health) ProjectPolled(projectName string, reviews int, err error) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    status := h.project(projectName)
+    status.LastPoll = time.Now()
+
+    if err != nil {
+        status.LastError = err.Error()
+        return
+    }
+
+    status.LastSuccess = status.LastPoll
+    status.LastError = ""
+    status.Reviews = reviews
+}
+
+/*
+    Время следующего опроса проекта, по нему видно, что опрос не завис
+ */
+func (h *Health) ProjectScheduled(projectName string, next time.Time) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    h.project(projectName).NextPoll = next
+}
+
+func (h *Health) RemoveProject(projectName string) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    delete(h.projects, projectName)
+}
+
+/*
+    Очередь, размер которой показывается в /status и /readyz
+ */
+func (h *Health) AddQueue(name string, depth func() int) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    h.queues[name] = depth
+}
+
+type HealthReport struct {
+    Status string `json:"status"`
+    Started time.Time `json:"started"`
+    Problems []string `json:"problems,omitempty"`
+    Components map[string]ComponentStatus `json:"components"`
+    Projects map[string]ProjectStatus `json:"projects"`
+    Queues map[string]int `json:"queues"`
+}
+
+/*
+    Допустимое опоздание опроса проекта и давность успешного опроса для готовности:
+    несколько обычных интервалов опроса, но не меньше минуты
+ */
+func pollStaleAfter(projectName string) time.Duration {
+    stale := 5 * CONFIG.Get().Project(projectName).PollInterval * time.Second
+
+    if stale < time.Minute {
+        stale = time.Minute
+    }
+
+    return stale
+}
+
+/*
+    Отчёт о состоянии. `ready` — проверка готовности: все компоненты в порядке и каждый проект
+    опрошен, а при ошибках опроса — недавно успешно. Иначе проверка живости: опрос проектов
+    не опаздывает относительно запланированного.
+ */
+func (h *Health) Report(ready bool) HealthReport {
+    return h.reportAt(ready, time.Now())
+}
+
+func (h *Health) reportAt(ready bool, now time.Time) (report HealthReport) {
+    h.mutex.Lock()
+    defer h.mutex.Unlock()
+
+    report = HealthReport{
+        Status: "ok",
+        Started: h.started,
+        Components: map[string]ComponentStatus{},
+        Projects: map[string]ProjectStatus{},
+        Queues: map[string]int{},
+    }
+
+    for name, status := range h.components {
+        report.Components[name] = status
+
+        if ready && !status.OK {
+            report.Problems = append(report.Problems, name+": "+status.Message)
+        }
+    }
+
+    for name, status := range h.projects {
+        report.Projects[name] = *status
+        stale := pollStaleAfter(name)
+
+        switch {
+        // Запланированный опрос давно не выполнен — горутина зависла
+        case !status.NextPoll.IsZero() && now.Sub(status.NextPoll) > stale:
+            report.Problems = append(report.Problems, name+": опрос не выполнен с "+status.NextPoll.Format(time.RFC3339))
+        case ready && status.LastError != "" && now.Sub(status.LastSuccess) > stale:
+            report.Problems = append(report.Problems, name+": нет успешного опроса с "+status.LastSuccess.Format(time.RFC3339))
+        }
+    }
+
+    for name, depth := range h.queues {
+        report.Queues[name] = depth()
+    }
+
+    if ready {
+        for projectName := range CONFIG.Get().ProjectMap {
+            if status, ok := h.projects[projectName]; !ok || status.LastPoll.IsZero() {
+                report.Problems = append(report.Problems, projectName+": ещё не опрошен")
+            }
+        }
+    }
+
+    sort.Strings(report.Problems)
+
+    if len(report.Problems) > 0 {
+        report.Status = "fail"
+    }
+
+    return
+}
+
+func writeHealthReport(writer http.ResponseWriter, report HealthReport, failOnProblems bool) {
+    writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+    if failOnProblems && report.Status != "ok" {
+        writer.WriteHeader(http.StatusServiceUnavailable)
+    }
+
+    encoder := json.NewEncoder(writer)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(report)
+}
+
+func healthzHandler(writer http.ResponseWriter, request *http.Request) {
+    writeHealthReport(writer, health.Report(false), true)
+}
+
+func readyzHandler(writer http.ResponseWriter, request *http.Request) {
+    writeHealthReport(writer, health.Report(true), true)
+}
+
+func statusHandler(writer http.ResponseWriter, request *http.Request) {
+    writeHealthReport(writer, health.Report(true), false)
+}
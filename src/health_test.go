@@ -0,0 +1,89 @@
+package main
+
+import (
+    "./crucible"
+    "strings"
+    "testing"
+    "time"
+)
+
+/*
+    Конфиг с одним проектом "P": опрос раз в 10 секунд, без разброса интервала
+ */
+func setPollingConfig(t *testing.T, triggers TriggerConfig, polling PollingConfig) {
+    jitter := 0
+    polling.Jitter = &jitter
+
+    CONFIG.Set(Config{
+        Crucible: crucible.Config{Timeout: 10},
+        ProjectMap: map[string]ProjectConfig{"P": {}},
+        Triggers: triggers,
+        Polling: polling,
+    })
+
+    health = newHealth()
+
+    t.Cleanup(func() {
+        CONFIG.Set(Config{})
+        health = newHealth()
+        lastTrigger.time = time.Time{}
+    })
+}
+
+func expectProblems(t *testing.T, now time.Time, want string) {
+    t.Helper()
+
+    problems := strings.Join(health.reportAt(false, now).Problems, "; ")
+
+    if want == "" && problems != "" || !strings.Contains(problems, want) {
+        t.Errorf("в %v проблемы %q, ожидалось %q", now.Format("15:04:05"), problems, want)
+    }
+}
+
+/*
+    Пока приходят вызовы из Crucible, опрос реже обычного — это не зависание
+ */
+func TestHealthTriggersActive(t *testing.T) {
+    setPollingConfig(t, TriggerConfig{Path: "/crucible", PollInterval: 600}, PollingConfig{})
+    triggerReceived()
+
+    polled := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    poller := &projectPoller{name: "P"}
+    poller.scheduleNext(polled)
+
+    expectProblems(t, polled.Add(5*time.Minute), "")
+    expectProblems(t, polled.Add(10*time.Minute + 30*time.Second), "")
+    expectProblems(t, polled.Add(12*time.Minute), "P: опрос не выполнен")
+}
+
+/*
+    При ошибках Crucible интервал растёт до `maxBackoff`, опрос по расписанию не считается зависшим
+ */
+func TestHealthBackoff(t *testing.T) {
+    setPollingConfig(t, TriggerConfig{}, PollingConfig{})
+
+    polled := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    poller := &projectPoller{name: "P", errors: 5}
+    poller.scheduleNext(polled)
+
+    // 10 секунд × 2^5
+    expectProblems(t, polled.Add(6*time.Minute), "")
+    expectProblems(t, polled.Add(7*time.Minute), "P: опрос не выполнен")
+
+    poller.errors = 20
+    poller.scheduleNext(polled)
+
+    expectProblems(t, polled.Add(30*time.Minute + 30*time.Second), "")
+    expectProblems(t, polled.Add(32*time.Minute), "P: опрос не выполнен")
+}
+
+func TestHealthQuietHours(t *testing.T) {
+    setPollingConfig(t, TriggerConfig{}, PollingConfig{QuietFrom: 20, QuietTo: 8, Timezone: "UTC", QuietFactor: 20})
+
+    polled := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+    poller := &projectPoller{name: "P"}
+    poller.scheduleNext(polled)
+
+    expectProblems(t, polled.Add(3*time.Minute + 30*time.Second), "")
+    expectProblems(t, polled.Add(5*time.Minute), "P: опрос не выполнен")
+}
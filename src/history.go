@@ -0,0 +1,170 @@
+package main
+
+import (
+    "./crucible"
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// Сколько дней хранить ревью в истории по умолчанию
+const defaultHistoryDays = 90
+
+type HistoryConfig struct {
+    // Файл истории ревью, если не указан — история хранится только в памяти
+    File string `json:"file"`
+    // Сколько дней хранить ревью, которые больше не обновляются
+    Days int `json:"days"`
+}
+
+type historyEntry struct {
+    Review crucible.Review `json:"review"`
+    LastSeen time.Time `json:"lastSeen"`
+}
+
+/*
+    История ревью всех проектов: последнее известное состояние каждого ревью
+ */
+type ReviewHistory struct {
+    mutex sync.Mutex
+    // Сохранения по очереди: периодическое и при выходе бота не пишут файл одновременно
+    saveMutex sync.Mutex
+    config HistoryConfig
+    reviews map[string]historyEntry
+}
+
+var reviewHistory = &ReviewHistory{reviews: map[string]historyEntry{}}
+
+func LoadReviewHistory(config HistoryConfig) (history *ReviewHistory, err error) {
+    history = &ReviewHistory{config: config, reviews: map[string]historyEntry{}}
+
+    if config.File == "" {
+        return
+    }
+
+    data, err := ioutil.ReadFile(config.File)
+
+    if os.IsNotExist(err) {
+        return history, nil
+    }
+
+    if err != nil {
+        return
+    }
+
+    err = json.Unmarshal(data, &history.reviews)
+    return
+}
+
+/*
+    Запоминает состояние ревью и удаляет устаревшие записи
+ */
+func (history *ReviewHistory) Update(reviews []crucible.Review) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+
+    now := time.Now()
+
+    for _, review := range reviews {
+        history.reviews[review.GetID()] = historyEntry{Review: review, LastSeen: now}
+    }
+
+    days := history.config.Days
+    if days <= 0 {
+        days = defaultHistoryDays
+    }
+
+    expired := now.AddDate(0, 0, -days)
+
+    for id, entry := range history.reviews {
+        if entry.LastSeen.Before(expired) {
+            delete(history.reviews, id)
+        }
+    }
+}
+
+/*
+    Забывает удалённое ревью
+ */
+func (history *ReviewHistory) Remove(id string) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+
+    delete(history.reviews, id)
+}
+
+/*
+    Ревью проекта из истории, для пустого `projectName` — всех проектов
+ */
+func (history *ReviewHistory) Reviews(projectName string) (reviews []crucible.Review) {
+    history.mutex.Lock()
+    defer history.mutex.Unlock()
+
+    for _, entry := range history.reviews {
+        if projectName == "" || entry.Review.ProjectKey == projectName {
+            reviews = append(reviews, entry.Review)
+        }
+    }
+
+    return
+}
+
+func (history *ReviewHistory) Save() (err error) {
+    if history.config.File == "" {
+        return
+    }
+
+    history.saveMutex.Lock()
+    defer history.saveMutex.Unlock()
+
+    history.mutex.Lock()
+    data, err := json.Marshal(history.reviews)
+    history.mutex.Unlock()
+
+    if err != nil {
+        return
+    }
+
+    return writeFileAtomic(history.config.File, data, 0644)
+}
+
+/*
+    Записывает файл целиком или не меняет его: пишет во временный файл в том же каталоге
+    и переименовывает, так падение посреди записи не обрежет прежнее содержимое
+ */
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+    file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+
+    if err != nil {
+        return
+    }
+
+    defer func() {
+        if err != nil {
+            os.Remove(file.Name())
+        }
+    }()
+
+    _, err = file.Write(data)
+
+    if err == nil {
+        err = file.Sync()
+    }
+
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+
+    if err == nil {
+        err = os.Chmod(file.Name(), perm)
+    }
+
+    if err != nil {
+        return
+    }
+
+    return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+    "./crucible"
+    "encoding/json"
+    "io/ioutil"
+    "path/filepath"
+    "sync"
+    "testing"
+)
+
+/*
+    Одновременные сохранения не портят файл и не оставляют временных файлов
+ */
+func TestReviewHistorySaveConcurrent(t *testing.T) {
+    dir := t.TempDir()
+    file := filepath.Join(dir, "history.json")
+
+    history, err := LoadReviewHistory(HistoryConfig{File: file})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var wg sync.WaitGroup
+
+    for i := 0; i < 20; i++ {
+        review := crucible.Review{Name: "Ревью"}
+        review.PermaID.ID = "CR-" + string(rune('A'+i))
+
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            history.Update([]crucible.Review{review})
+        }()
+        go func() {
+            defer wg.Done()
+
+            if err := history.Save(); err != nil {
+                t.Error(err)
+            }
+        }()
+    }
+
+    wg.Wait()
+
+    if err := history.Save(); err != nil {
+        t.Fatal(err)
+    }
+
+    data, err := ioutil.ReadFile(file)
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var saved map[string]historyEntry
+
+    if err := json.Unmarshal(data, &saved); err != nil || len(saved) != 20 {
+        t.Errorf("в файле %d ревью, ошибка %v", len(saved), err)
+    }
+
+    if files, _ := filepath.Glob(filepath.Join(dir, "*")); len(files) != 1 {
+        t.Errorf("файлы в каталоге истории: %v", files)
+    }
+}
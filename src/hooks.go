@@ -0,0 +1,577 @@
+package main
+
+import (
+    "./crucible"
+    "./metrics"
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Версия схемы JSON, меняется только при несовместимых изменениях
+const hookSchemaVersion = 1
+
+// Типы событий для исходящих webhook
+const (
+    HookReviewCreated = "review.created"
+    HookReviewUpdated = "review.updated"
+    HookReviewOpened = "review.opened"
+    HookReviewCompleted = "review.completed"
+    HookReviewRemoved = "review.removed"
+)
+
+var hookEventTypes = []string{HookReviewCreated, HookReviewUpdated, HookReviewOpened, HookReviewCompleted, HookReviewRemoved}
+
+// Изменения ревью в `changes`, в рамках версии схемы список только пополняется
+const (
+    HookChangeName = "name"
+    HookChangeDescription = "description"
+    HookChangeState = "state"
+    // Переход, которого не бывает в Crucible
+    HookChangeStateUnexpected = "state.unexpected"
+    HookChangeReviewersCompleted = "reviewers.completed"
+    HookChangeReviewersJoined = "reviewers.joined"
+    HookChangeReviewersLeft = "reviewers.left"
+)
+
+// Изменения из crucible.Compare -> имена в схеме webhook
+var hookChanges = map[string]string{
+    "name": HookChangeName,
+    "description": HookChangeDescription,
+    "state": HookChangeState,
+    "state.unexpected": HookChangeStateUnexpected,
+    "reviewers.complited": HookChangeReviewersCompleted,
+    "reviewers.join": HookChangeReviewersJoined,
+    "reviewers.leave": HookChangeReviewersLeft,
+}
+
+var hookDeliveriesTotal = metrics.NewCounter(
+    "reviewbot_hook_deliveries_total",
+    "Попытки доставки исходящих webhook по результату: sent, retry, failed",
+    "hook", "result",
+)
+
+/*
+    Исходящие webhook для своей автоматизации: на каждое событие ревью POST запрос
+    с JSON и подписью HMAC-SHA256
+ */
+type HooksConfig struct {
+    Endpoints []HookConfig `json:"endpoints"`
+    // Файл журнала доставок, по строке JSON на попытку, пусто — журнал только в памяти
+    LogFile string `json:"logFile"`
+    // Сколько последних доставок показывать в /hooks, по умолчанию 100
+    LogSize int `json:"logSize"`
+    // Показывать журнал в /hooks на HTTP сервере `http.listen`. Журнал отдаётся без авторизации,
+    // включайте, только если сервер закрыт от чужих
+    ServeLog bool `json:"serveLog"`
+}
+
+// Сколько байт ответа получателя сохранять в ошибке доставки
+const hookErrorBodyLimit = 1024
+
+type HookConfig struct {
+    // Имя для журнала и метрик, по умолчанию хост из адреса
+    Name string `json:"name"`
+    // В адресе бывает токен получателя, в журнал и метрики он не попадает
+    URL string `json:"url" secret:"true"`
+    // Файл с адресом, вместо `url`
+    URLFile string `json:"urlFile"`
+    // Ключ подписи, заголовок X-Reviewbot-Signature: sha256=<hex> — HMAC-SHA256 от
+    // "<X-Reviewbot-Timestamp>.<тело запроса>". Время в секундах Unix стоит проверять,
+    // чтобы перехваченный запрос нельзя было повторить позже
+    Secret string `json:"secret" secret:"true"`
+    // Файл с ключом, вместо `secret`
+    SecretFile string `json:"secretFile"`
+    // Типы событий, по умолчанию все
+    Events []string `json:"events"`
+    // Проекты, по умолчанию все
+    Projects []string `json:"projects"`
+    // Сколько раз повторять неудачную доставку, по умолчанию 5, 0 — без повторов
+    Retries *int `json:"retries"`
+    // Пауза перед первым повтором в секундах, дальше удваивается, по умолчанию 2
+    Backoff time.Duration `json:"backoff"`
+}
+
+func (config *HookConfig) GetRetries() int {
+    if config.Retries == nil {
+        return 5
+    }
+
+    return *config.Retries
+}
+
+func (config *HookConfig) GetName() string {
+    if config.Name != "" {
+        return config.Name
+    }
+
+    // Только хост: в пути и параметрах адреса бывают токены
+    if hookURL, err := url.Parse(config.URL); err == nil && hookURL.Host != "" {
+        return hookURL.Host
+    }
+
+    return "webhook"
+}
+
+/*
+    Ревью в исходящем webhook: только поля, которые мы обещаем не менять
+ */
+type HookReview struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+    Description string `json:"description"`
+    URL string `json:"url"`
+    State crucible.State `json:"state"`
+    Author string `json:"author"`
+    JiraIssueKey string `json:"jiraIssueKey,omitempty"`
+    CreateDate time.Time `json:"createDate"`
+    CloseDate *time.Time `json:"closeDate,omitempty"`
+    Completed bool `json:"completed"`
+    Reviewers []HookReviewer `json:"reviewers"`
+}
+
+type HookReviewer struct {
+    UserName string `json:"userName"`
+    DisplayName string `json:"displayName"`
+    Completed bool `json:"completed"`
+}
+
+type HookPayload struct {
+    Version int `json:"version"`
+    // Id события, одинаковый у всех повторов доставки
+    ID string `json:"id"`
+    Type string `json:"type"`
+    Time time.Time `json:"time"`
+    Project string `json:"project"`
+    Review HookReview `json:"review"`
+    // Состояние до изменения, нет у нового ревью
+    Previous *HookReview `json:"previous,omitempty"`
+    // Изменившиеся поля, см. HookChangeName
+    Changes []string `json:"changes"`
+    // Почему ревью пропало, только у review.removed: deleted, moved, forbidden
+    Reason string `json:"reason,omitempty"`
+}
+
+func hookReview(project ProjectConfig, review crucible.Review) HookReview {
+    snapshot := HookReview{
+        ID: review.GetID(),
+        Name: review.Name,
+        Description: review.Description,
+        URL: review.GetURL(CONFIG.Get().Crucible.Host),
+        State: review.GetState(),
+        Author: review.GetAuthorNick(),
+        JiraIssueKey: review.JiraIssueKey,
+        CreateDate: review.CreateDate.Time,
+        Completed: project.IsCompleted(review),
+        Reviewers: []HookReviewer{},
+    }
+
+    if !review.CloseDate.IsZero() {
+        closeDate := review.CloseDate.Time
+        snapshot.CloseDate = &closeDate
+    }
+
+    for _, reviewer := range review.Reviewers.Reviewer {
+        snapshot.Reviewers = append(snapshot.Reviewers, HookReviewer{
+            UserName: reviewer.UserName,
+            DisplayName: reviewer.DisplayName,
+            Completed: reviewer.Completed,
+        })
+    }
+
+    return snapshot
+}
+
+/*
+    Тип события для webhook: открытие и завершение важнее простого обновления
+ */
+func hookEventType(event ReviewEvent, notification string) string {
+    switch {
+    case notification == EventCompleted:
+        return HookReviewCompleted
+    case notification == EventOpened:
+        return HookReviewOpened
+    case event.OldRev.GetID() == "":
+        return HookReviewCreated
+    }
+
+    return HookReviewUpdated
+}
+
+func newHookPayload(event ReviewEvent, eventType string, changes []string) HookPayload {
+    project := CONFIG.Get().Project(event.ProjectName)
+
+    payload := HookPayload{
+        Version: hookSchemaVersion,
+        ID: newDeliveryID(),
+        Type: eventType,
+        Time: time.Now().UTC(),
+        Project: event.ProjectName,
+        Review: hookReview(project, event.NewRev),
+        Changes: []string{},
+        Reason: event.Removed,
+    }
+
+    for _, change := range changes {
+        if name, ok := hookChanges[change]; ok {
+            payload.Changes = append(payload.Changes, name)
+        }
+    }
+
+    if event.OldRev.GetID() != "" {
+        previous := hookReview(project, event.OldRev)
+        payload.Previous = &previous
+    }
+
+    return payload
+}
+
+func newDeliveryID() string {
+    id := make([]byte, 16)
+
+    if _, err := rand.Read(id); err != nil {
+        return strconv.FormatInt(time.Now().UnixNano(), 16)
+    }
+
+    return hex.EncodeToString(id)
+}
+
+func signHook(secret string, timestamp string, body []byte) string {
+    mac := hmac.New(sha256.New, []byte(secret))
+    mac.Write([]byte(timestamp + "."))
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+/*
+    Запись журнала доставок, одна на каждую попытку
+ */
+type HookDelivery struct {
+    ID string `json:"id"`
+    Hook string `json:"hook"`
+    Type string `json:"type"`
+    Project string `json:"project"`
+    ReviewID string `json:"reviewId"`
+    Attempt int `json:"attempt"`
+    Time time.Time `json:"time"`
+    Duration float64 `json:"durationSeconds"`
+    Status int `json:"status,omitempty"`
+    Error string `json:"error,omitempty"`
+    // sent, retry или failed
+    Result string `json:"result"`
+}
+
+type HookLog struct {
+    mutex sync.Mutex
+    size int
+    file string
+    entries []HookDelivery
+}
+
+func (hookLog *HookLog) Add(delivery HookDelivery) {
+    hookLog.mutex.Lock()
+    defer hookLog.mutex.Unlock()
+
+    hookLog.entries = append(hookLog.entries, delivery)
+
+    if len(hookLog.entries) > hookLog.size {
+        hookLog.entries = hookLog.entries[len(hookLog.entries)-hookLog.size:]
+    }
+
+    if hookLog.file == "" {
+        return
+    }
+
+    line, err := json.Marshal(delivery)
+
+    if err != nil {
+        return
+    }
+
+    file, err := os.OpenFile(hookLog.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+
+    if err != nil {
+        log.Println("Ошибка записи журнала webhook", hookLog.file, err)
+        return
+    }
+
+    defer file.Close()
+
+    file.Write(append(line, '\n'))
+}
+
+/*
+    Последние доставки, сначала новые
+ */
+func (hookLog *HookLog) Entries() []HookDelivery {
+    hookLog.mutex.Lock()
+    defer hookLog.mutex.Unlock()
+
+    entries := make([]HookDelivery, 0, len(hookLog.entries))
+
+    for i := len(hookLog.entries) - 1; i >= 0; i-- {
+        entries = append(entries, hookLog.entries[i])
+    }
+
+    return entries
+}
+
+/*
+    Рассылка событий по исходящим webhook, у каждого адреса своя очередь,
+    чтобы повторы одного не задерживали остальные
+ */
+type HookDispatcher struct {
+    hooks []HookConfig
+    queues []chan HookPayload
+    log *HookLog
+    wg sync.WaitGroup
+}
+
+var hookDispatcher = NewHookDispatcher(context.Background(), HooksConfig{})
+
+func NewHookDispatcher(ctx context.Context, config HooksConfig) *HookDispatcher {
+    size := config.LogSize
+    if size <= 0 {
+        size = 100
+    }
+
+    dispatcher := &HookDispatcher{
+        hooks: config.Endpoints,
+        log: &HookLog{size: size, file: config.LogFile},
+    }
+
+    for _, hook := range config.Endpoints {
+        queue := make(chan HookPayload, 100)
+        dispatcher.queues = append(dispatcher.queues, queue)
+
+        health.AddQueue("hook:"+hook.GetName(), func() int { return len(queue) })
+
+        dispatcher.wg.Add(1)
+        go dispatcher.run(ctx, hook, queue)
+    }
+
+    return dispatcher
+}
+
+/*
+    Ставит событие в очереди подходящих webhook
+ */
+func (dispatcher *HookDispatcher) Emit(event ReviewEvent, eventType string, changes []string) {
+    if len(dispatcher.hooks) == 0 {
+        return
+    }
+
+    payload := newHookPayload(event, eventType, changes)
+
+    for i, hook := range dispatcher.hooks {
+        if !matchAny(hook.Events, eventType) || !matchAny(hook.Projects, event.ProjectName) {
+            continue
+        }
+
+        select {
+        case dispatcher.queues[i] <- payload:
+        default:
+            log.Println("Очередь webhook переполнена, событие пропущено", hook.GetName(), payload.ID)
+            hookDeliveriesTotal.Inc(hook.GetName(), "failed")
+        }
+    }
+}
+
+/*
+    Закрывает очереди и ждёт, пока разойдутся оставшиеся события
+ */
+func (dispatcher *HookDispatcher) Close() {
+    for _, queue := range dispatcher.queues {
+        close(queue)
+    }
+
+    dispatcher.wg.Wait()
+}
+
+func (dispatcher *HookDispatcher) Log() *HookLog {
+    return dispatcher.log
+}
+
+func (dispatcher *HookDispatcher) run(ctx context.Context, hook HookConfig, queue chan HookPayload) {
+    defer dispatcher.wg.Done()
+
+    for payload := range queue {
+        dispatcher.deliver(ctx, hook, payload)
+    }
+}
+
+/*
+    Доставка с повторами: пауза удваивается после каждой неудачи.
+    Ответ 4xx, кроме 408 и 429, не повторяется — запрос не изменится
+ */
+func (dispatcher *HookDispatcher) deliver(ctx context.Context, hook HookConfig, payload HookPayload) {
+    body, err := json.Marshal(payload)
+
+    if err != nil {
+        log.Println("Ошибка формирования webhook", hook.GetName(), err)
+        return
+    }
+
+    retries := hook.GetRetries()
+
+    backoff := hook.Backoff * time.Second
+    if backoff <= 0 {
+        backoff = 2 * time.Second
+    }
+
+    for attempt := 1; ; attempt++ {
+        started := time.Now()
+        status, err := postHook(ctx, hook, payload, body)
+
+        delivery := HookDelivery{
+            ID: payload.ID,
+            Hook: hook.GetName(),
+            Type: payload.Type,
+            Project: payload.Project,
+            ReviewID: payload.Review.ID,
+            Attempt: attempt,
+            Time: started,
+            Duration: time.Since(started).Seconds(),
+            Status: status,
+            Result: "sent",
+        }
+
+        if err == nil {
+            dispatcher.log.Add(delivery)
+            hookDeliveriesTotal.Inc(hook.GetName(), "sent")
+            return
+        }
+
+        delivery.Error = err.Error()
+        retryable := status == 0 || status >= 500 || status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+
+        if !retryable || attempt > retries || ctx.Err() != nil {
+            delivery.Result = "failed"
+            dispatcher.log.Add(delivery)
+            hookDeliveriesTotal.Inc(hook.GetName(), "failed")
+            log.Println("Не удалось доставить webhook", hook.GetName(), payload.ID, err)
+            return
+        }
+
+        delivery.Result = "retry"
+        dispatcher.log.Add(delivery)
+        hookDeliveriesTotal.Inc(hook.GetName(), "retry")
+
+        if !sleepContext(ctx, backoff) {
+            return
+        }
+
+        backoff *= 2
+    }
+}
+
+func postHook(ctx context.Context, hook HookConfig, payload HookPayload, body []byte) (status int, err error) {
+    req, err := http.NewRequestWithContext(ctx, "POST", hook.URL, bytes.NewReader(body))
+
+    if err != nil {
+        return
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "reviewbot")
+    req.Header.Set("X-Reviewbot-Event", payload.Type)
+    req.Header.Set("X-Reviewbot-Delivery", payload.ID)
+
+    // Время подписи своё у каждой попытки, получатель может отбрасывать старые запросы
+    timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+    req.Header.Set("X-Reviewbot-Timestamp", timestamp)
+
+    if hook.Secret != "" {
+        req.Header.Set("X-Reviewbot-Signature", signHook(hook.Secret, timestamp, body))
+    }
+
+    response, err := hookHTTPClient.Do(req)
+
+    // Ошибка запроса содержит адрес, а с ним и токен, журнал доступен по /hooks
+    if urlErr, ok := err.(*url.Error); ok {
+        err = errors.New(fmt.Sprint(hook.GetName(), ": ", urlErr.Err))
+    }
+
+    if err != nil {
+        return
+    }
+
+    defer response.Body.Close()
+
+    status = response.StatusCode
+
+    if status >= 300 {
+        text, _ := ioutil.ReadAll(io.LimitReader(response.Body, hookErrorBodyLimit))
+        err = errors.New(fmt.Sprint(hook.GetName(), " вернул ", response.Status, " ", string(text)))
+    }
+
+    return
+}
+
+var hookHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+/*
+    Журнал доставок webhook для HTTP сервера
+ */
+func hooksHandler(writer http.ResponseWriter, request *http.Request) {
+    writer.Header().Set("Content-Type", "application/json")
+
+    encoder := json.NewEncoder(writer)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(hookDispatcher.Log().Entries())
+}
+
+func validateHooks(errs *ConfigErrors, config HooksConfig, projectMap map[string]ProjectConfig) {
+    if config.LogSize < 0 {
+        errs.Add("hooks.logSize", "не может быть отрицательным")
+    }
+
+    names := map[string]bool{}
+
+    for i, hook := range config.Endpoints {
+        path := fmt.Sprintf("hooks.endpoints[%d]", i)
+
+        validateURL(errs, path+".url", hook.URL)
+
+        if names[hook.GetName()] {
+            errs.Add(path+".name", "имя %q уже занято, укажите другое", hook.GetName())
+        }
+
+        names[hook.GetName()] = true
+
+        for _, event := range hook.Events {
+            if !containsString(hookEventTypes, event) {
+                errs.Add(path+".events", "неизвестный тип события %q", event)
+            }
+        }
+
+        for _, projectName := range hook.Projects {
+            if _, ok := projectMap[projectName]; !ok {
+                errs.Add(path+".projects", "проекта %q нет в projectMap", projectName)
+            }
+        }
+
+        if hook.GetRetries() < 0 {
+            errs.Add(path+".retries", "не может быть отрицательным")
+        }
+
+        if hook.Backoff < 0 {
+            errs.Add(path+".backoff", "не может быть отрицательным")
+        }
+    }
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+/*
+    Ошибка доставки попадает в журнал /hooks: в ней нет адреса с токеном и длинного ответа
+ */
+func TestPostHookErrorHidesURL(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+        writer.WriteHeader(http.StatusBadRequest)
+        writer.Write([]byte(strings.Repeat("x", 10*hookErrorBodyLimit)))
+    }))
+    defer server.Close()
+
+    hook := HookConfig{URL: server.URL + "/hooks/secret-token"}
+    status, err := postHook(context.Background(), hook, HookPayload{}, []byte("{}"))
+
+    if status != http.StatusBadRequest || err == nil {
+        t.Fatalf("postHook = %d %v, ожидалась ошибка 400", status, err)
+    }
+
+    if strings.Contains(err.Error(), "secret-token") || len(err.Error()) > 2*hookErrorBodyLimit {
+        t.Errorf("ошибка раскрывает адрес или слишком длинная: %.200s", err)
+    }
+
+    server.Close()
+    _, err = postHook(context.Background(), hook, HookPayload{}, []byte("{}"))
+
+    if err == nil || strings.Contains(err.Error(), "secret-token") {
+        t.Errorf("ошибка соединения раскрывает адрес: %v", err)
+    }
+}
+
+func TestHookDefaultName(t *testing.T) {
+    hook := HookConfig{URL: "https://ci.example.com/hooks/secret-token?key=1"}
+
+    if name := hook.GetName(); name != "ci.example.com" {
+        t.Errorf("GetName = %q", name)
+    }
+
+    errs := ConfigErrors{}
+    validateHooks(&errs, HooksConfig{Endpoints: []HookConfig{hook, hook}}, nil)
+
+    if len(errs) != 1 || !strings.Contains(errs.Error(), "endpoints[1].name") {
+        t.Errorf("ожидалась ошибка одинаковых имён, получено %v", errs)
+    }
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+    "./crucible"
+    "bytes"
+    "context"
+    "fmt"
+    "html/template"
+    "log"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/*
+    Письма пользователям со списком ревью, которые ждут их, и их ревью, которые можно закрывать.
+    Адреса берутся из `userMap`
+ */
+type MailDigestConfig struct {
+    // Не чаще чем раз в столько минут одному пользователю, 0 — письма выключены
+    Window time.Duration `json:"window"`
+    // Проекты, по умолчанию все из projectMap
+    Projects []string `json:"projects"`
+    Subject string `json:"subject"`
+}
+
+func (config *MailDigestConfig) Enabled() bool {
+    return config.Window > 0
+}
+
+func (config *MailDigestConfig) GetProjects() []string {
+    if len(config.Projects) > 0 {
+        return config.Projects
+    }
+
+    projects := []string{}
+
+    for projectName := range CONFIG.Get().ProjectMap {
+        projects = append(projects, projectName)
+    }
+
+    sort.Strings(projects)
+    return projects
+}
+
+type mailDigestItem struct {
+    Project string
+    Review crucible.Review
+    Title string
+    AuthorName string
+    URL string
+    Progress string
+}
+
+/*
+    Письмо одному пользователю
+ */
+type mailDigest struct {
+    User string
+    // Ревью, в которых пользователь ещё не завершил просмотр
+    Awaiting []mailDigestItem
+    // Ревью пользователя, которые все просмотрели
+    Completed []mailDigestItem
+}
+
+/*
+    Ключи ревью в письме: новое письмо уходит, только если появилось что-то, чего не было в прошлом
+ */
+func (digest *mailDigest) Keys() map[string]bool {
+    keys := map[string]bool{}
+
+    for _, item := range digest.Awaiting {
+        keys["awaiting:"+item.Review.GetID()] = true
+    }
+
+    for _, item := range digest.Completed {
+        keys["completed:"+item.Review.GetID()] = true
+    }
+
+    return keys
+}
+
+var mailDigestHTML = template.Must(template.New("digest").Parse(`<html><body>
+{{if .Awaiting}}<h3>Ждут вашего ревью ({{len .Awaiting}})</h3>
+<ul>{{range .Awaiting}}
+<li><a href="{{.URL}}">{{.Title}}</a> [{{.Project}}], автор {{.AuthorName}}<br><small>{{.Progress}}</small></li>{{end}}
+</ul>{{end}}
+{{if .Completed}}<h3>Ваши ревью можно закрывать ({{len .Completed}})</h3>
+<ul>{{range .Completed}}
+<li><a href="{{.URL}}">{{.Title}}</a> [{{.Project}}]<br><small>{{.Progress}}</small></li>{{end}}
+</ul>{{end}}
+</body></html>
+`))
+
+func (digest *mailDigest) Plain() string {
+    lines := []string{}
+
+    if len(digest.Awaiting) > 0 {
+        lines = append(lines, fmt.Sprintf("Ждут вашего ревью (%d):", len(digest.Awaiting)), "")
+
+        for _, item := range digest.Awaiting {
+            lines = append(lines, fmt.Sprintf("* %s [%s], автор %s", item.Title, item.Project, item.AuthorName), "  "+item.URL)
+        }
+
+        lines = append(lines, "")
+    }
+
+    if len(digest.Completed) > 0 {
+        lines = append(lines, fmt.Sprintf("Ваши ревью можно закрывать (%d):", len(digest.Completed)), "")
+
+        for _, item := range digest.Completed {
+            lines = append(lines, fmt.Sprintf("* %s [%s]", item.Title, item.Project), "  "+item.URL)
+        }
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+func (digest *mailDigest) HTML() (string, error) {
+    var buffer bytes.Buffer
+    err := mailDigestHTML.Execute(&buffer, digest)
+    return buffer.String(), err
+}
+
+/*
+    Письма по всем пользователям с адресом почты, по ревью из GetReviews
+ */
+func collectMailDigests(ctx context.Context, crucibleClient *crucible.Crucible, config MailDigestConfig) (digests map[string]*mailDigest, err error) {
+    digests = map[string]*mailDigest{}
+    host := CONFIG.Get().Crucible.Host
+
+    digestFor := func(userName string) *mailDigest {
+        if CONFIG.Get().UserMap[userName].Email == "" {
+            return nil
+        }
+
+        if digests[userName] == nil {
+            digests[userName] = &mailDigest{User: userName}
+        }
+
+        return digests[userName]
+    }
+
+    for _, projectName := range config.GetProjects() {
+        reviews, err := crucibleClient.GetReviews(ctx, crucible.GetReviewsOptions{
+            Project: projectName,
+            States: []crucible.State{crucible.StateReview},
+        })
+
+        if err != nil {
+            return nil, err
+        }
+
+        project := CONFIG.Get().Project(projectName)
+
+        for _, review := range reviews.Reviews {
+            item := mailDigestItem{
+                Project: projectName,
+                Review: review,
+                Title: review.Name,
+                AuthorName: review.GetAuthorName(),
+                URL: review.GetURL(host),
+                Progress: reviewProgress(NotifierSlack, review),
+            }
+
+            if item.Title == "" {
+                item.Title = review.GetID()
+            }
+
+            if project.IsCompleted(review) {
+                if digest := digestFor(review.GetAuthorNick()); digest != nil {
+                    digest.Completed = append(digest.Completed, item)
+                }
+                continue
+            }
+
+            for _, name := range review.GetPendingReviewersNames() {
+                if digest := digestFor(name); digest != nil {
+                    digest.Awaiting = append(digest.Awaiting, item)
+                }
+            }
+        }
+    }
+
+    return
+}
+
+/*
+    Раз в окно `window` собирает ревью и отправляет письма тем, у кого появилось новое.
+    Изменения за окно приходят одним письмом, а не письмом на каждый опрос Crucible
+ */
+func watchMailDigests(ctx context.Context, crucibleClient *crucible.Crucible, config MailDigestConfig, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    // Что было в последнем письме каждому пользователю
+    sent := map[string]map[string]bool{}
+
+    subject := config.Subject
+    if subject == "" {
+        subject = "Ревью в Crucible"
+    }
+
+    for {
+        digests, err := collectMailDigests(ctx, crucibleClient, config)
+
+        if err != nil {
+            log.Println("Ошибка получения ревью для писем", err)
+        }
+
+        for userName, digest := range digests {
+            keys := digest.Keys()
+            fresh := false
+
+            for key := range keys {
+                fresh = fresh || !sent[userName][key]
+            }
+
+            if !fresh {
+                // Запоминаем, что ушло из списка, чтобы снова написать, если ревью вернётся
+                sent[userName] = keys
+                continue
+            }
+
+            err := sendMailDigest(ctx, CONFIG.Get().UserMap[userName].Email, subject, digest)
+
+            if err != nil {
+                log.Println("Ошибка отправки письма", userName, err)
+                continue
+            }
+
+            sent[userName] = keys
+        }
+
+        // Пустой список: в следующем письме пользователю всё будет новым
+        if err == nil {
+            for userName := range sent {
+                if digests[userName] == nil {
+                    delete(sent, userName)
+                }
+            }
+        }
+
+        if !sleepContext(ctx, config.Window * time.Minute) {
+            return
+        }
+    }
+}
+
+func sendMailDigest(ctx context.Context, to string, subject string, digest *mailDigest) (err error) {
+    html, err := digest.HTML()
+
+    if err != nil {
+        return
+    }
+
+    smtp := CONFIG.Get().SMTP
+    result := "failed"
+    defer func() {
+        mailDigestsTotal.Inc(result)
+    }()
+
+    err = smtp.SendAlternative(ctx, []string{to}, subject, digest.Plain(), html)
+
+    if err == nil {
+        result = "sent"
+    }
+
+    return
+}
+
+func validateMailDigest(errs *ConfigErrors, config Config) {
+    digest := config.MailDigest
+
+    if digest.Window < 0 {
+        errs.Add("mailDigest.window", "не может быть отрицательным")
+    }
+
+    if !digest.Enabled() {
+        return
+    }
+
+    if config.SMTP.Host == "" {
+        errs.Add("smtp.host", "нужен SMTP сервер для mailDigest")
+    }
+
+    for _, projectName := range digest.Projects {
+        if _, ok := config.ProjectMap[projectName]; !ok {
+            errs.Add("mailDigest.projects", "проекта %q нет в projectMap", projectName)
+        }
+    }
+
+    found := false
+
+    for _, user := range config.UserMap {
+        found = found || user.Email != ""
+    }
+
+    if !found {
+        errs.Add("userMap", "ни у одного пользователя не указан email для mailDigest")
+    }
+}
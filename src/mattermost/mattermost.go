@@ -0,0 +1,386 @@
+package mattermost
+
+import (
+    "../metrics"
+    "../slack"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+    "sync"
+    "time"
+    "golang.org/x/net/websocket"
+)
+
+// Документация https://api.mattermost.com/
+
+var postsTotal = metrics.NewCounter(
+    "reviewbot_mattermost_posts_total",
+    "Сообщения, отправленные в Mattermost, по результату: sent, failed",
+    "result",
+)
+
+type Config struct {
+    Host string `json:"host"`
+    // Токен бота или персональный токен доступа
+    Token string `json:"token" secret:"true"`
+    // Файл с токеном, вместо `token`
+    TokenFile string `json:"tokenFile"`
+    // Команда (team), в которой ищутся каналы по имени
+    Team string `json:"team"`
+    // Служебный канал
+    Channel string `json:"channel"`
+}
+
+func (config *Config) Enabled() bool {
+    return config.Host != ""
+}
+
+type User struct {
+    ID string `json:"id"`
+    Username string `json:"username"`
+}
+
+type Channel struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+}
+
+// https://api.mattermost.com/#tag/posts
+type Post struct {
+    ID string `json:"id,omitempty"`
+    ChannelID string `json:"channel_id"`
+    UserID string `json:"user_id,omitempty"`
+    RootID string `json:"root_id,omitempty"`
+    Message string `json:"message"`
+    CreateAt int64 `json:"create_at,omitempty"`
+    Props map[string]interface{} `json:"props,omitempty"`
+}
+
+func (post *Post) GetTime() time.Time {
+    return time.Unix(0, post.CreateAt*int64(time.Millisecond))
+}
+
+// Событие websocket https://api.mattermost.com/#tag/WebSocket
+type Event struct {
+    Event string `json:"event"`
+    Data map[string]interface{} `json:"data"`
+    Seq int `json:"seq"`
+}
+
+/*
+    Новое сообщение из события `posted`, вместе с именем канала
+ */
+func (event *Event) Posted() (post Post, channelName string, ok bool) {
+    if event.Event != "posted" {
+        return
+    }
+
+    raw, _ := event.Data["post"].(string)
+
+    if json.Unmarshal([]byte(raw), &post) != nil {
+        return
+    }
+
+    channelName, _ = event.Data["channel_name"].(string)
+    return post, channelName, true
+}
+
+type Client struct {
+    url *url.URL
+    httpClient *http.Client
+    config Config
+
+    mutex sync.Mutex
+    me User
+    // Имена и id каналов и пользователей, чтобы не запрашивать их на каждое сообщение
+    channelIDs map[string]string
+    channelNames map[string]string
+    userNames map[string]string
+}
+
+func CreateClient(config Config) (client *Client, err error) {
+    client = &Client{
+        httpClient: &http.Client{Timeout: 10 * time.Second},
+        config: config,
+        channelIDs: map[string]string{},
+        channelNames: map[string]string{},
+        userNames: map[string]string{},
+    }
+
+    client.url, err = url.Parse(config.Host)
+    return
+}
+
+func (client *Client) do(ctx context.Context, method string, path string, data interface{}, result interface{}) (err error) {
+    apiUrl := *client.url
+    apiUrl.Path = strings.TrimRight(apiUrl.Path, "/") + "/api/v4" + path
+
+    var body io.Reader
+
+    if data != nil {
+        encoded, err := json.Marshal(data)
+
+        if err != nil {
+            return err
+        }
+
+        body = bytes.NewReader(encoded)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, method, apiUrl.String(), body)
+
+    if err != nil {
+        return
+    }
+
+    req.Header.Set("Authorization", "Bearer "+client.config.Token)
+    req.Header.Set("Content-Type", "application/json")
+
+    response, err := client.httpClient.Do(req)
+
+    if err != nil {
+        return
+    }
+
+    defer response.Body.Close()
+
+    responseBody, err := ioutil.ReadAll(response.Body)
+
+    if err != nil {
+        return
+    }
+
+    if response.StatusCode >= 300 {
+        return errors.New(fmt.Sprint("Mattermost: ", method, " ", path, " вернул ", response.Status, " ", string(responseBody)))
+    }
+
+    if result == nil {
+        return
+    }
+
+    return json.Unmarshal(responseBody, result)
+}
+
+/*
+    Проверка токена, запоминает пользователя бота, чтобы не отвечать на свои сообщения
+ */
+func (client *Client) TestAuth(ctx context.Context) (me User, err error) {
+    err = client.do(ctx, "GET", "/users/me", nil, &me)
+
+    if err != nil {
+        return
+    }
+
+    client.mutex.Lock()
+    client.me = me
+    client.mutex.Unlock()
+    return
+}
+
+func (client *Client) Me() User {
+    client.mutex.Lock()
+    defer client.mutex.Unlock()
+
+    return client.me
+}
+
+func (client *Client) RememberChannel(channel Channel) {
+    client.mutex.Lock()
+    defer client.mutex.Unlock()
+
+    client.channelIDs[channel.Name] = channel.ID
+    client.channelNames[channel.ID] = channel.Name
+}
+
+/*
+    Id канала по имени или id, `#` в начале имени не обязателен, `@имя` — личные сообщения
+ */
+func (client *Client) ChannelID(ctx context.Context, name string) (id string, err error) {
+    name = strings.TrimPrefix(name, "#")
+
+    client.mutex.Lock()
+    id, known := client.channelIDs[name]
+    _, isID := client.channelNames[name]
+    client.mutex.Unlock()
+
+    if isID {
+        return name, nil
+    }
+
+    if known {
+        return
+    }
+
+    if strings.HasPrefix(name, "@") {
+        return client.directChannelID(ctx, strings.TrimPrefix(name, "@"))
+    }
+
+    var channel Channel
+    err = client.do(ctx, "GET", "/teams/name/"+url.PathEscape(client.config.Team)+"/channels/name/"+url.PathEscape(name), nil, &channel)
+
+    if err != nil {
+        return
+    }
+
+    client.RememberChannel(channel)
+    return channel.ID, nil
+}
+
+/*
+    Канал личных сообщений бота с пользователем, создаётся, если его ещё нет
+ */
+func (client *Client) directChannelID(ctx context.Context, userName string) (id string, err error) {
+    me := client.Me()
+
+    if me.ID == "" {
+        me, err = client.TestAuth(ctx)
+
+        if err != nil {
+            return
+        }
+    }
+
+    var user User
+    err = client.do(ctx, "GET", "/users/username/"+url.PathEscape(userName), nil, &user)
+
+    if err != nil {
+        return
+    }
+
+    var channel Channel
+    err = client.do(ctx, "POST", "/channels/direct", []string{me.ID, user.ID}, &channel)
+
+    if err != nil {
+        return
+    }
+
+    client.RememberChannel(Channel{ID: channel.ID, Name: "@" + userName})
+    return channel.ID, nil
+}
+
+func (client *Client) ChannelName(ctx context.Context, id string) string {
+    client.mutex.Lock()
+    name, ok := client.channelNames[id]
+    client.mutex.Unlock()
+
+    if ok {
+        return name
+    }
+
+    var channel Channel
+
+    if client.do(ctx, "GET", "/channels/"+url.PathEscape(id), nil, &channel) != nil {
+        return ""
+    }
+
+    client.RememberChannel(channel)
+    return channel.Name
+}
+
+func (client *Client) UserName(ctx context.Context, id string) string {
+    client.mutex.Lock()
+    name, ok := client.userNames[id]
+    client.mutex.Unlock()
+
+    if ok {
+        return name
+    }
+
+    var user User
+
+    if client.do(ctx, "GET", "/users/"+url.PathEscape(id), nil, &user) != nil {
+        return ""
+    }
+
+    client.mutex.Lock()
+    client.userNames[id] = user.Username
+    client.mutex.Unlock()
+
+    return user.Username
+}
+
+func (client *Client) CreatePost(ctx context.Context, post Post) (created Post, err error) {
+    result := "failed"
+    defer func() {
+        postsTotal.Inc(result)
+    }()
+
+    err = client.do(ctx, "POST", "/posts", post, &created)
+
+    if err == nil {
+        result = "sent"
+    }
+
+    return
+}
+
+/*
+    Отправка сообщения в формате Slack: вложения Mattermost понимает в `props.attachments`,
+    тред — `root_id`, вместо `ts` возвращается id сообщения
+ */
+func (client *Client) PostMessage(ctx context.Context, message slack.Message) (posted slack.PostMessageResponse, err error) {
+    channelID, err := client.ChannelID(ctx, message.Channel)
+
+    if err != nil {
+        return
+    }
+
+    post := Post{
+        ChannelID: channelID,
+        RootID: message.ThreadTs,
+        Message: message.Text,
+    }
+
+    if len(message.Attachments) > 0 {
+        post.Props = map[string]interface{}{"attachments": message.Attachments}
+    }
+
+    created, err := client.CreatePost(ctx, post)
+
+    if err != nil {
+        return
+    }
+
+    // Тред сообщения — его корень, если оно само в треде
+    root := created.RootID
+    if root == "" {
+        root = created.ID
+    }
+
+    return slack.PostMessageResponse{Ok: true, Channel: message.Channel, Ts: root}, nil
+}
+
+/*
+    Подключение к websocket событий с авторизацией по токену
+ */
+func (client *Client) Dial() (ws *websocket.Conn, err error) {
+    wsUrl := *client.url
+    wsUrl.Path = strings.TrimRight(wsUrl.Path, "/") + "/api/v4/websocket"
+
+    switch wsUrl.Scheme {
+    case "https":
+        wsUrl.Scheme = "wss"
+    default:
+        wsUrl.Scheme = "ws"
+    }
+
+    origin := *client.url
+    origin.Path = "/"
+
+    config, err := websocket.NewConfig(wsUrl.String(), origin.String())
+
+    if err != nil {
+        return
+    }
+
+    config.Header.Set("Authorization", "Bearer "+client.config.Token)
+
+    return websocket.DialConfig(config)
+}
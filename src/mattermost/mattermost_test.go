@@ -0,0 +1,220 @@
+package mattermost
+
+import (
+    "../slack"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "sync"
+    "testing"
+)
+
+/*
+    Заглушка Mattermost API: канал "reviews" команды "dev" и создание сообщений
+ */
+type fakeServer struct {
+    mutex sync.Mutex
+    channelLookups int
+    directChannels [][]string
+    posts []Post
+}
+
+func (fake *fakeServer) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+    fake.mutex.Lock()
+    defer fake.mutex.Unlock()
+
+    if request.Header.Get("Authorization") != "Bearer token" {
+        http.Error(writer, "unauthorized", http.StatusUnauthorized)
+        return
+    }
+
+    switch {
+    case request.Method == "GET" && request.URL.Path == "/api/v4/teams/name/dev/channels/name/reviews":
+        fake.channelLookups++
+        json.NewEncoder(writer).Encode(Channel{ID: "channel-1", Name: "reviews"})
+    case request.Method == "GET" && request.URL.Path == "/api/v4/users/me":
+        json.NewEncoder(writer).Encode(User{ID: "bot-id", Username: "reviewbot"})
+    case request.Method == "GET" && request.URL.Path == "/api/v4/users/username/alice":
+        json.NewEncoder(writer).Encode(User{ID: "alice-id", Username: "alice"})
+    case request.Method == "POST" && request.URL.Path == "/api/v4/channels/direct":
+        var members []string
+        json.NewDecoder(request.Body).Decode(&members)
+
+        fake.directChannels = append(fake.directChannels, members)
+        json.NewEncoder(writer).Encode(Channel{ID: "direct-1", Name: "bot-id__alice-id"})
+    case request.Method == "POST" && request.URL.Path == "/api/v4/posts":
+        var post Post
+        json.NewDecoder(request.Body).Decode(&post)
+
+        post.ID = "post-" + string(rune('0'+len(fake.posts)))
+        fake.posts = append(fake.posts, post)
+
+        json.NewEncoder(writer).Encode(post)
+    default:
+        http.NotFound(writer, request)
+    }
+}
+
+func newTestClient(t *testing.T) (*Client, *fakeServer) {
+    fake := &fakeServer{}
+    server := httptest.NewServer(fake)
+    t.Cleanup(server.Close)
+
+    client, err := CreateClient(Config{Host: server.URL, Token: "token", Team: "dev"})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    return client, fake
+}
+
+func TestChannelIDCached(t *testing.T) {
+    client, fake := newTestClient(t)
+    ctx := context.Background()
+
+    for _, name := range []string{"reviews", "#reviews", "channel-1"} {
+        id, err := client.ChannelID(ctx, name)
+
+        if err != nil {
+            t.Fatal(name, err)
+        }
+
+        if id != "channel-1" {
+            t.Errorf("ChannelID(%q) = %q, ожидался channel-1", name, id)
+        }
+    }
+
+    if fake.channelLookups != 1 {
+        t.Errorf("канал запрошен %d раз, ожидался один запрос", fake.channelLookups)
+    }
+
+    if name := client.ChannelName(ctx, "channel-1"); name != "reviews" {
+        t.Errorf("ChannelName = %q, ожидался reviews", name)
+    }
+}
+
+func TestChannelIDUnknown(t *testing.T) {
+    client, _ := newTestClient(t)
+
+    if _, err := client.ChannelID(context.Background(), "missing"); err == nil {
+        t.Error("ожидалась ошибка для неизвестного канала")
+    }
+}
+
+func TestChannelIDDirect(t *testing.T) {
+    client, fake := newTestClient(t)
+    ctx := context.Background()
+
+    for i := 0; i < 2; i++ {
+        id, err := client.ChannelID(ctx, "@alice")
+
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        if id != "direct-1" {
+            t.Errorf("ChannelID(@alice) = %q, ожидался direct-1", id)
+        }
+    }
+
+    if len(fake.directChannels) != 1 {
+        t.Fatalf("личный канал создан %d раз, ожидался один", len(fake.directChannels))
+    }
+
+    if members := fake.directChannels[0]; len(members) != 2 || members[0] != "bot-id" || members[1] != "alice-id" {
+        t.Errorf("участники личного канала %v", members)
+    }
+}
+
+func TestPostMessageThread(t *testing.T) {
+    client, fake := newTestClient(t)
+    ctx := context.Background()
+
+    root, err := client.PostMessage(ctx, slack.Message{
+        Channel: "#reviews",
+        Text: "ревью",
+        Attachments: []slack.Attachment{{Title: "CR-1"}},
+    })
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if root.Ts != "post-0" || root.Channel != "#reviews" {
+        t.Errorf("ответ %+v, ожидался ts post-0 в #reviews", root)
+    }
+
+    reply, err := client.PostMessage(ctx, slack.Message{Channel: "reviews", Text: "комментарий", ThreadTs: root.Ts})
+
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if len(fake.posts) != 2 {
+        t.Fatalf("отправлено %d сообщений, ожидалось 2", len(fake.posts))
+    }
+
+    first, second := fake.posts[0], fake.posts[1]
+
+    if first.ChannelID != "channel-1" || first.RootID != "" || first.Props["attachments"] == nil {
+        t.Errorf("первое сообщение %+v", first)
+    }
+
+    if second.RootID != "post-0" {
+        t.Errorf("ThreadTs не попал в root_id: %+v", second)
+    }
+
+    // Ответ в треде возвращает корень треда, а не своё id
+    if reply.Ts != "post-0" {
+        t.Errorf("ts ответа %q, ожидался корень post-0", reply.Ts)
+    }
+}
+
+func TestEventPosted(t *testing.T) {
+    var event Event
+
+    data := `{
+        "event": "posted",
+        "seq": 7,
+        "data": {
+            "channel_name": "reviews",
+            "post": "{\"id\":\"p1\",\"channel_id\":\"c1\",\"user_id\":\"u1\",\"root_id\":\"r1\",\"message\":\"!stats\",\"create_at\":1500000000000}"
+        }
+    }`
+
+    if err := json.Unmarshal([]byte(data), &event); err != nil {
+        t.Fatal(err)
+    }
+
+    post, channelName, ok := event.Posted()
+
+    if !ok {
+        t.Fatal("событие posted не разобрано")
+    }
+
+    if post.ID != "p1" || post.ChannelID != "c1" || post.UserID != "u1" || post.RootID != "r1" || post.Message != "!stats" {
+        t.Errorf("сообщение %+v", post)
+    }
+
+    if channelName != "reviews" {
+        t.Errorf("канал %q, ожидался reviews", channelName)
+    }
+
+    if post.GetTime().Unix() != 1500000000 {
+        t.Errorf("время %v", post.GetTime())
+    }
+}
+
+func TestEventPostedOther(t *testing.T) {
+    for _, event := range []Event{
+        {Event: "typing", Data: map[string]interface{}{"post": "{}"}},
+        {Event: "posted", Data: map[string]interface{}{"post": "не json"}},
+        {Event: "posted", Data: map[string]interface{}{}},
+    } {
+        if _, _, ok := event.Posted(); ok {
+            t.Errorf("событие %+v не должно разбираться как сообщение", event)
+        }
+    }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+    "./crucible"
+    "./metrics"
+)
+
+var pollDuration = metrics.NewHistogram(
+    "reviewbot_crucible_poll_duration_seconds",
+    "Время получения списка ревью проекта",
+    nil,
+    "project",
+)
+
+var pollErrorsTotal = metrics.NewCounter(
+    "reviewbot_crucible_poll_errors_total",
+    "Ошибки получения списка ревью проекта",
+    "project",
+)
+
+var pollNotModifiedTotal = metrics.NewCounter(
+    "reviewbot_crucible_poll_not_modified_total",
+    "Опросы проекта, на которые Crucible вернул тот же список ревью",
+    "project",
+)
+
+var reviewsTracked = metrics.NewGauge(
+    "reviewbot_reviews_tracked",
+    "Отслеживаемые ревью проекта по состояниям",
+    "project", "state",
+)
+
+var eventsTotal = metrics.NewCounter(
+    "reviewbot_events_total",
+    "События по ревью по типам: review.new, review.update, comment, reply",
+    "type",
+)
+
+var notificationsTotal = metrics.NewCounter(
+    "reviewbot_notifications_total",
+    "Уведомления об изменении ревью по типам: opened, completed, unexpected_transition",
+    "type",
+)
+
+var websocketReconnectsTotal = metrics.NewCounter(
+    "reviewbot_slack_websocket_reconnects_total",
+    "Переподключения websocket к Slack RTM и Mattermost после обрыва соединения",
+)
+
+var reviewsRemovedTotal = metrics.NewCounter(
+    "reviewbot_reviews_removed_total",
+    "Ревью, пропавшие из списка проекта: missing — пропали, после проверки deleted, moved, forbidden или expired — вышли из окна lookback",
+    "project", "reason",
+)
+
+var mailDigestsTotal = metrics.NewCounter(
+    "reviewbot_mail_digests_total",
+    "Письма пользователям со списком ревью по результату: sent, failed",
+    "result",
+)
+
+/*
+    Обновляет количество отслеживаемых ревью проекта по состояниям
+ */
+func trackReviews(projectName string, reviews []crucible.Review) {
+    counts := map[crucible.State]int{}
+
+    for _, review := range reviews {
+        counts[review.GetState()]++
+    }
+
+    for _, state := range crucible.States {
+        reviewsTracked.Set(float64(counts[state]), projectName, state.String())
+    }
+}
@@ -0,0 +1,235 @@
+package metrics
+
+import (
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// Границы гистограмм по умолчанию, в секундах
+var DefaultBuckets = []float64{.05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type series struct {
+    labelValues []string
+    value float64
+    // Для гистограмм: количество наблюдений по границам, сумма и общее количество
+    buckets []uint64
+    sum float64
+    count uint64
+}
+
+type family struct {
+    name string
+    help string
+    kind string
+    labelNames []string
+    bucketBounds []float64
+    series map[string]*series
+}
+
+type Registry struct {
+    mutex sync.Mutex
+    families []*family
+}
+
+var Default = &Registry{}
+
+func (registry *Registry) register(f *family) *family {
+    registry.mutex.Lock()
+    defer registry.mutex.Unlock()
+
+    f.series = map[string]*series{}
+    registry.families = append(registry.families, f)
+    return f
+}
+
+/*
+    Серия метрики по значениям меток, создаётся при первом обращении
+ */
+func (registry *Registry) get(f *family, labelValues []string) *series {
+    if len(labelValues) != len(f.labelNames) {
+        panic(fmt.Sprintf("metrics: %s ожидает метки %v, получено %v", f.name, f.labelNames, labelValues))
+    }
+
+    key := strings.Join(labelValues, "\xff")
+    s, ok := f.series[key]
+
+    if !ok {
+        s = &series{labelValues: append([]string{}, labelValues...)}
+
+        if f.kind == "histogram" {
+            s.buckets = make([]uint64, len(f.bucketBounds))
+        }
+
+        f.series[key] = s
+    }
+
+    return s
+}
+
+type Counter struct {
+    registry *Registry
+    family *family
+}
+
+func NewCounter(name string, help string, labelNames ...string) *Counter {
+    return &Counter{Default, Default.register(&family{name: name, help: help, kind: "counter", labelNames: labelNames})}
+}
+
+func (counter *Counter) Inc(labelValues ...string) {
+    counter.Add(1, labelValues...)
+}
+
+func (counter *Counter) Add(value float64, labelValues ...string) {
+    counter.registry.mutex.Lock()
+    defer counter.registry.mutex.Unlock()
+
+    counter.registry.get(counter.family, labelValues).value += value
+}
+
+type Gauge struct {
+    registry *Registry
+    family *family
+}
+
+func NewGauge(name string, help string, labelNames ...string) *Gauge {
+    return &Gauge{Default, Default.register(&family{name: name, help: help, kind: "gauge", labelNames: labelNames})}
+}
+
+func (gauge *Gauge) Set(value float64, labelValues ...string) {
+    gauge.registry.mutex.Lock()
+    defer gauge.registry.mutex.Unlock()
+
+    gauge.registry.get(gauge.family, labelValues).value = value
+}
+
+func (gauge *Gauge) Add(value float64, labelValues ...string) {
+    gauge.registry.mutex.Lock()
+    defer gauge.registry.mutex.Unlock()
+
+    gauge.registry.get(gauge.family, labelValues).value += value
+}
+
+type Histogram struct {
+    registry *Registry
+    family *family
+}
+
+func NewHistogram(name string, help string, buckets []float64, labelNames ...string) *Histogram {
+    if buckets == nil {
+        buckets = DefaultBuckets
+    }
+
+    bounds := append([]float64{}, buckets...)
+    sort.Float64s(bounds)
+
+    return &Histogram{Default, Default.register(&family{name: name, help: help, kind: "histogram", labelNames: labelNames, bucketBounds: bounds})}
+}
+
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+    histogram.registry.mutex.Lock()
+    defer histogram.registry.mutex.Unlock()
+
+    s := histogram.registry.get(histogram.family, labelValues)
+
+    for i, bound := range histogram.family.bucketBounds {
+        if value <= bound {
+            s.buckets[i]++
+        }
+    }
+
+    s.sum += value
+    s.count++
+}
+
+func formatValue(value float64) string {
+    switch {
+    case math.IsInf(value, 1):
+        return "+Inf"
+    case math.IsInf(value, -1):
+        return "-Inf"
+    }
+
+    return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func escapeLabel(value string) string {
+    return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatLabels(names []string, values []string, extra ...string) string {
+    pairs := []string{}
+
+    for i, name := range names {
+        pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(values[i])))
+    }
+
+    for i := 0; i+1 < len(extra); i += 2 {
+        pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabel(extra[i+1])))
+    }
+
+    if len(pairs) == 0 {
+        return ""
+    }
+
+    return "{" + strings.Join(pairs, ",") + "}"
+}
+
+/*
+    Все метрики в текстовом формате Prometheus
+ */
+func (registry *Registry) WriteText(writer io.Writer) (err error) {
+    registry.mutex.Lock()
+    defer registry.mutex.Unlock()
+
+    for _, f := range registry.families {
+        _, err = fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+
+        if err != nil {
+            return
+        }
+
+        keys := make([]string, 0, len(f.series))
+        for key := range f.series {
+            keys = append(keys, key)
+        }
+        sort.Strings(keys)
+
+        for _, key := range keys {
+            s := f.series[key]
+
+            if f.kind != "histogram" {
+                fmt.Fprintf(writer, "%s%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues), formatValue(s.value))
+                continue
+            }
+
+            for i, bound := range f.bucketBounds {
+                fmt.Fprintf(writer, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", formatValue(bound)), s.buckets[i])
+            }
+
+            fmt.Fprintf(writer, "%s_bucket%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues, "le", "+Inf"), s.count)
+            fmt.Fprintf(writer, "%s_sum%s %s\n", f.name, formatLabels(f.labelNames, s.labelValues), formatValue(s.sum))
+            _, err = fmt.Fprintf(writer, "%s_count%s %d\n", f.name, formatLabels(f.labelNames, s.labelValues), s.count)
+
+            if err != nil {
+                return
+            }
+        }
+    }
+
+    return
+}
+
+func Handler() http.Handler {
+    return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+        writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+        Default.WriteText(writer)
+    })
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+    "./notify"
+    "./slack"
+    "sort"
+    "strings"
+)
+
+// Типы получателей уведомлений
+const (
+    NotifierSlack = "slack"
+    NotifierWebhook = "webhook"
+    NotifierMattermost = "mattermost"
+    NotifierTeams = "teams"
+    NotifierEmail = "email"
+)
+
+var notifierTypes = []string{NotifierSlack, NotifierWebhook, NotifierMattermost, NotifierTeams, NotifierEmail}
+
+/*
+    Получатель уведомлений, на который ссылаются правила `routes` по имени
+ */
+type NotifierConfig struct {
+    // slack, webhook, mattermost, teams или email
+    Type string `json:"type"`
+    // Канал Slack или Mattermost
+    Channel string `json:"channel"`
+    // Адрес webhook, входящего webhook Mattermost или коннектора Teams.
+    // Без адреса Mattermost отправка идёт через API с настройками из `mattermost`.
+    // Адрес входящего webhook — сам по себе ключ доступа
+    URL string `json:"url" secret:"true"`
+    // Файл с адресом, вместо `url`
+    URLFile string `json:"urlFile"`
+    // Имя и иконка бота в Mattermost
+    Username string `json:"username"`
+    IconURL string `json:"iconUrl"`
+    // Адреса почты, сервер берётся из `smtp`
+    To []string `json:"to"`
+}
+
+func (config *NotifierConfig) Notifier(slackClient slack.SlackClient) notify.Notifier {
+    switch config.Type {
+    case NotifierWebhook:
+        return &notify.Webhook{URL: config.URL}
+    case NotifierMattermost:
+        // Без адреса входящего webhook — через REST API от имени бота
+        if config.URL == "" && mattermostClient != nil {
+            return &notify.Chat{Kind: NotifierMattermost, Client: mattermostClient, Channel: config.Channel}
+        }
+
+        return &notify.Mattermost{URL: config.URL, Channel: config.Channel, Username: config.Username, IconURL: config.IconURL}
+    case NotifierTeams:
+        return &notify.Teams{URL: config.URL}
+    case NotifierEmail:
+        return &notify.Email{SMTP: CONFIG.Get().SMTP, To: config.To}
+    }
+
+    return &notify.Chat{Kind: NotifierSlack, Client: &slackClient, Channel: strings.TrimPrefix(config.Channel, "#")}
+}
+
+func validateNotifiers(errs *ConfigErrors, config Config) {
+    names := []string{}
+
+    for name := range config.Notifiers {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    for _, name := range names {
+        notifier := config.Notifiers[name]
+        path := "notifiers." + name
+
+        switch notifier.Type {
+        case NotifierSlack:
+            validateRequired(errs, path+".channel", notifier.Channel)
+        case NotifierMattermost:
+            if notifier.URL != "" {
+                validateURL(errs, path+".url", notifier.URL)
+            } else if !config.Mattermost.Enabled() {
+                errs.Add(path+".url", "нужен адрес входящего webhook или настройки mattermost")
+            } else {
+                validateRequired(errs, path+".channel", notifier.Channel)
+            }
+        case NotifierWebhook, NotifierTeams:
+            validateURL(errs, path+".url", notifier.URL)
+        case NotifierEmail:
+            if len(notifier.To) == 0 {
+                errs.Add(path+".to", "обязательное поле")
+            }
+
+            if config.SMTP.Host == "" {
+                errs.Add("smtp.host", "нужен SMTP сервер для получателя %s", name)
+            }
+        default:
+            errs.Add(path+".type", "неизвестный тип %q, возможны: %s", notifier.Type, strings.Join(notifierTypes, ", "))
+        }
+    }
+
+    if config.SMTP.Host != "" && config.SMTP.From == "" {
+        errs.Add("smtp.from", "обязательное поле")
+    }
+
+    if config.SMTP.Port < 0 || config.SMTP.Port > 65535 {
+        errs.Add("smtp.port", "неверный порт %d", config.SMTP.Port)
+    }
+}
@@ -0,0 +1,57 @@
+package notify
+
+import (
+    "../slack"
+    "context"
+)
+
+/*
+    Отправка сообщений в формате Slack: клиент Slack или Mattermost
+ */
+type Poster interface {
+    PostMessage(ctx context.Context, message slack.Message) (slack.PostMessageResponse, error)
+}
+
+/*
+    Канал или личные сообщения (@nick) в Slack или Mattermost
+ */
+type Chat struct {
+    // slack или mattermost
+    Kind string
+    Client Poster
+    Channel string
+    // Вызывается после успешной отправки, по ответу бот находит тред ревью
+    OnPosted func(response slack.PostMessageResponse)
+}
+
+func (notifier *Chat) Name() string {
+    return notifier.Kind + ":" + notifier.Channel
+}
+
+func (notifier *Chat) Notify(ctx context.Context, notification Notification) error {
+    if notifier.Kind == "mattermost" {
+        notification = notification.ForMattermost()
+    }
+
+    message := slack.Message{
+        Text: notification.Text,
+        Channel: notifier.Channel,
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    message.AddAttachment(slack.Attachment{
+        AuthorName: notification.AuthorName,
+        Title:      notification.Title,
+        TitleLink:  notification.URL,
+        Color:      notification.Color,
+    })
+
+    response, err := notifier.Client.PostMessage(ctx, message)
+
+    if err == nil && notifier.OnPosted != nil {
+        notifier.OnPosted(response)
+    }
+
+    return err
+}
@@ -0,0 +1,211 @@
+package notify
+
+import (
+    "bytes"
+    "context"
+    "crypto/tls"
+    "fmt"
+    "mime"
+    "mime/multipart"
+    "net"
+    "net/smtp"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Ограничение на подключение и весь разговор с SMTP сервером, если у контекста нет своего срока
+const smtpTimeout = 30 * time.Second
+
+type SMTPConfig struct {
+    Host string `json:"host"`
+    Port int `json:"port"`
+    Username string `json:"username"`
+    Password string `json:"password" secret:"true"`
+    // Файл с паролем, вместо `password`
+    PasswordFile string `json:"passwordFile"`
+    From string `json:"from"`
+}
+
+func (config *SMTPConfig) Addr() string {
+    port := config.Port
+    if port == 0 {
+        port = 25
+    }
+
+    return net.JoinHostPort(config.Host, strconv.Itoa(port))
+}
+
+/*
+    Письмо через SMTP сервер
+ */
+func (config *SMTPConfig) Send(ctx context.Context, to []string, subject string, contentType string, body string) error {
+    var auth smtp.Auth
+
+    if config.Username != "" {
+        auth = smtp.PlainAuth("", config.Username, config.Password, config.Host)
+    }
+
+    headers := []string{
+        "From: " + config.From,
+        "To: " + strings.Join(to, ", "),
+        "Subject: " + mime.QEncoding.Encode("utf-8", subject),
+        "Date: " + time.Now().Format(time.RFC1123Z),
+        "MIME-Version: 1.0",
+        "Content-Type: " + contentType,
+        "Content-Transfer-Encoding: 8bit",
+    }
+
+    message := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Replace(strings.Replace(body, "\r\n", "\n", -1), "\n", "\r\n", -1)
+
+    return config.sendMail(ctx, auth, to, []byte(message))
+}
+
+/*
+    То же, что smtp.SendMail, но с таймаутом: зависший сервер не блокирует отправку остальных уведомлений
+    и остановку бота
+ */
+func (config *SMTPConfig) sendMail(ctx context.Context, auth smtp.Auth, to []string, message []byte) (err error) {
+    ctx, cancel := context.WithTimeout(ctx, smtpTimeout)
+    defer cancel()
+
+    dialer := net.Dialer{Timeout: smtpTimeout}
+    conn, err := dialer.DialContext(ctx, "tcp", config.Addr())
+
+    if err != nil {
+        return
+    }
+
+    deadline, _ := ctx.Deadline()
+    conn.SetDeadline(deadline)
+
+    // Отмена контекста прерывает чтение и запись
+    stop := make(chan struct{})
+    defer close(stop)
+
+    go func() {
+        select {
+        case <-ctx.Done():
+            conn.SetDeadline(time.Now())
+        case <-stop:
+        }
+    }()
+
+    client, err := smtp.NewClient(conn, config.Host)
+
+    if err != nil {
+        conn.Close()
+        return
+    }
+
+    defer client.Close()
+
+    if ok, _ := client.Extension("STARTTLS"); ok {
+        if err = client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+            return
+        }
+    }
+
+    if auth != nil {
+        if ok, _ := client.Extension("AUTH"); ok {
+            if err = client.Auth(auth); err != nil {
+                return
+            }
+        }
+    }
+
+    if err = client.Mail(config.From); err != nil {
+        return
+    }
+
+    for _, address := range to {
+        if err = client.Rcpt(address); err != nil {
+            return
+        }
+    }
+
+    writer, err := client.Data()
+
+    if err != nil {
+        return
+    }
+
+    if _, err = writer.Write(message); err != nil {
+        return
+    }
+
+    if err = writer.Close(); err != nil {
+        return
+    }
+
+    return client.Quit()
+}
+
+/*
+    Письмо с текстовой и HTML версиями, почтовый клиент покажет ту, которую умеет
+ */
+func (config *SMTPConfig) SendAlternative(ctx context.Context, to []string, subject string, plain string, html string) error {
+    var body bytes.Buffer
+    writer := multipart.NewWriter(&body)
+
+    parts := []struct {
+        contentType string
+        content string
+    }{
+        {"text/plain; charset=utf-8", plain},
+        {"text/html; charset=utf-8", html},
+    }
+
+    for _, part := range parts {
+        header := textproto.MIMEHeader{}
+        header.Set("Content-Type", part.contentType)
+        header.Set("Content-Transfer-Encoding", "8bit")
+
+        partWriter, err := writer.CreatePart(header)
+
+        if err != nil {
+            return err
+        }
+
+        if _, err := partWriter.Write([]byte(part.content)); err != nil {
+            return err
+        }
+    }
+
+    if err := writer.Close(); err != nil {
+        return err
+    }
+
+    return config.Send(ctx, to, subject, "multipart/alternative; boundary="+writer.Boundary(), body.String())
+}
+
+/*
+    Уведомление письмом на указанные адреса
+ */
+type Email struct {
+    SMTP SMTPConfig
+    To []string
+}
+
+func (notifier *Email) Name() string {
+    return "email:" + strings.Join(notifier.To, ",")
+}
+
+func (notifier *Email) Notify(ctx context.Context, notification Notification) error {
+    subject := fmt.Sprintf("[%s] %s", notification.Project, notification.Title)
+
+    lines := []string{
+        notification.Text,
+        "",
+        "Ревью: " + notification.Title,
+        "Автор: " + notification.Review.GetAuthorNick(),
+        "Ревьюверы: " + strings.Join(notification.Review.GetReviewersNames(), ", "),
+    }
+
+    if notification.URL != "" {
+        lines = append(lines, notification.URL)
+    }
+
+    return notifier.SMTP.Send(ctx, notifier.To, subject, "text/plain; charset=utf-8", strings.Join(lines, "\n"))
+}
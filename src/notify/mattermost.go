@@ -0,0 +1,55 @@
+package notify
+
+import (
+    "context"
+)
+
+/*
+    Входящий webhook Mattermost, формат сообщений совместим со Slack
+    https://developers.mattermost.com/integrate/webhooks/incoming/
+ */
+type Mattermost struct {
+    URL string
+    // Канал, если не указан — канал, к которому привязан webhook
+    Channel string
+    Username string
+    IconURL string
+}
+
+type mattermostAttachment struct {
+    Fallback string `json:"fallback"`
+    Color string `json:"color,omitempty"`
+    AuthorName string `json:"author_name,omitempty"`
+    Title string `json:"title"`
+    TitleLink string `json:"title_link,omitempty"`
+}
+
+type mattermostMessage struct {
+    Text string `json:"text"`
+    Channel string `json:"channel,omitempty"`
+    Username string `json:"username,omitempty"`
+    IconURL string `json:"icon_url,omitempty"`
+    Attachments []mattermostAttachment `json:"attachments,omitempty"`
+}
+
+func (notifier *Mattermost) Name() string {
+    return "mattermost:" + notifier.URL + "#" + notifier.Channel
+}
+
+func (notifier *Mattermost) Notify(ctx context.Context, notification Notification) error {
+    notification = notification.ForMattermost()
+
+    return postJSON(ctx, notifier.URL, mattermostMessage{
+        Text: notification.Text,
+        Channel: notifier.Channel,
+        Username: notifier.Username,
+        IconURL: notifier.IconURL,
+        Attachments: []mattermostAttachment{{
+            Fallback: notification.Text,
+            Color: notification.Color,
+            AuthorName: notification.AuthorName,
+            Title: notification.Title,
+            TitleLink: notification.URL,
+        }},
+    }, nil)
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+    "../crucible"
+    "../metrics"
+    "bytes"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strings"
+    "time"
+)
+
+var notificationsSent = metrics.NewCounter(
+    "reviewbot_notifier_deliveries_total",
+    "Отправка уведомлений по типу получателя и результату: sent, failed",
+    "notifier", "result",
+)
+
+/*
+    Уведомление о событии ревью, одинаковое для всех получателей
+ */
+type Notification struct {
+    // Тип события: opened, completed
+    Event string
+    Project string
+    Review crucible.Review
+    // Готовый текст уведомления
+    Text string
+    // Название ревью, если пустое — id
+    Title string
+    URL string
+    // Автор для показа, в Slack — с упоминанием
+    AuthorName string
+    // Цвет для Slack и Mattermost: good, warning, danger или #rrggbb
+    Color string
+    // Текст и автор с никами Mattermost, пусто — как в Slack
+    MattermostText string
+    MattermostAuthorName string
+}
+
+/*
+    Уведомление с никами Mattermost, если они отличаются от Slack
+ */
+func (notification Notification) ForMattermost() Notification {
+    if notification.MattermostText != "" {
+        notification.Text = notification.MattermostText
+    }
+
+    if notification.MattermostAuthorName != "" {
+        notification.AuthorName = notification.MattermostAuthorName
+    }
+
+    return notification
+}
+
+/*
+    Получатель уведомлений: канал Slack, webhook, Mattermost, Teams, почта
+ */
+type Notifier interface {
+    // Уникальное имя получателя, по нему убираются дубли
+    Name() string
+    Notify(ctx context.Context, notification Notification) error
+}
+
+/*
+    Отправка с учётом метрики, тип получателя — часть имени до двоеточия
+ */
+func Send(ctx context.Context, notifier Notifier, notification Notification) (err error) {
+    err = notifier.Notify(ctx, notification)
+
+    result := "sent"
+    if err != nil {
+        result = "failed"
+    }
+
+    notificationsSent.Inc(kind(notifier), result)
+    return
+}
+
+func kind(notifier Notifier) string {
+    return strings.SplitN(notifier.Name(), ":", 2)[0]
+}
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func postJSON(ctx context.Context, url string, data interface{}, headers map[string]string) (err error) {
+    body, err := json.Marshal(data)
+
+    if err != nil {
+        return
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+
+    if err != nil {
+        return
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+
+    for name, value := range headers {
+        req.Header.Set(name, value)
+    }
+
+    response, err := httpClient.Do(req)
+
+    if err != nil {
+        return
+    }
+
+    defer response.Body.Close()
+
+    if response.StatusCode >= 300 {
+        text, _ := ioutil.ReadAll(response.Body)
+        return errors.New(fmt.Sprint(url, " вернул ", response.Status, " ", string(text)))
+    }
+
+    return
+}
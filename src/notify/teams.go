@@ -0,0 +1,88 @@
+package notify
+
+import (
+    "context"
+    "strings"
+)
+
+/*
+    Коннектор Microsoft Teams, сообщение в формате MessageCard
+    https://learn.microsoft.com/en-us/outlook/actionable-messages/message-card-reference
+ */
+type Teams struct {
+    URL string
+}
+
+type teamsFact struct {
+    Name string `json:"name"`
+    Value string `json:"value"`
+}
+
+type teamsSection struct {
+    Facts []teamsFact `json:"facts"`
+}
+
+type teamsTarget struct {
+    OS string `json:"os"`
+    URI string `json:"uri"`
+}
+
+type teamsAction struct {
+    Type string `json:"@type"`
+    Name string `json:"name"`
+    Targets []teamsTarget `json:"targets"`
+}
+
+type teamsCard struct {
+    Type string `json:"@type"`
+    Context string `json:"@context"`
+    Summary string `json:"summary"`
+    ThemeColor string `json:"themeColor,omitempty"`
+    Title string `json:"title"`
+    Text string `json:"text"`
+    Sections []teamsSection `json:"sections"`
+    PotentialAction []teamsAction `json:"potentialAction,omitempty"`
+}
+
+// Цвета Slack в цвета Teams
+var teamsColors = map[string]string{
+    "good": "2EB886",
+    "warning": "DAA038",
+    "danger": "A30200",
+}
+
+func (notifier *Teams) Name() string {
+    return "teams:" + notifier.URL
+}
+
+func (notifier *Teams) Notify(ctx context.Context, notification Notification) error {
+    color := notification.Color
+
+    if teamsColor, ok := teamsColors[color]; ok {
+        color = teamsColor
+    }
+
+    card := teamsCard{
+        Type: "MessageCard",
+        Context: "https://schema.org/extensions",
+        Summary: notification.Text,
+        ThemeColor: strings.TrimPrefix(color, "#"),
+        Title: notification.Title,
+        Text: notification.Text,
+        Sections: []teamsSection{{Facts: []teamsFact{
+            {Name: "Проект", Value: notification.Project},
+            {Name: "Автор", Value: notification.Review.GetAuthorNick()},
+            {Name: "Ревьюверы", Value: strings.Join(notification.Review.GetReviewersNames(), ", ")},
+        }}},
+    }
+
+    if notification.URL != "" {
+        card.PotentialAction = []teamsAction{{
+            Type: "OpenUri",
+            Name: "Открыть ревью",
+            Targets: []teamsTarget{{OS: "default", URI: notification.URL}},
+        }}
+    }
+
+    return postJSON(ctx, notifier.URL, card, nil)
+}
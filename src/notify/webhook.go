@@ -0,0 +1,45 @@
+package notify
+
+import (
+    "../crucible"
+    "context"
+)
+
+/*
+    Уведомление POST запросом в JSON на произвольный адрес
+ */
+type Webhook struct {
+    URL string
+}
+
+type WebhookPayload struct {
+    Event string `json:"event"`
+    Project string `json:"project"`
+    Text string `json:"text"`
+    ReviewID string `json:"reviewId"`
+    Name string `json:"name"`
+    URL string `json:"url"`
+    State crucible.State `json:"state"`
+    Author string `json:"author"`
+    Reviewers []string `json:"reviewers"`
+}
+
+func (notifier *Webhook) Name() string {
+    return "webhook:" + notifier.URL
+}
+
+func (notifier *Webhook) Notify(ctx context.Context, notification Notification) error {
+    review := notification.Review
+
+    return postJSON(ctx, notifier.URL, WebhookPayload{
+        Event: notification.Event,
+        Project: notification.Project,
+        Text: notification.Text,
+        ReviewID: review.GetID(),
+        Name: review.Name,
+        URL: notification.URL,
+        State: review.GetState(),
+        Author: review.GetAuthorNick(),
+        Reviewers: review.GetReviewersNames(),
+    }, nil)
+}
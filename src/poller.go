@@ -0,0 +1,686 @@
+package main
+
+import (
+    "./crucible"
+    "context"
+    "log"
+    "math/rand"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+)
+
+// Опрос проектов при старте разносится по времени, но не дольше чем на столько
+const maxStartupDelay = time.Minute
+
+// Почему ревью пропало из списка проекта
+const (
+    // Удалено из Crucible
+    RemovalDeleted = "deleted"
+    // Перенесено в другой проект
+    RemovalMoved = "moved"
+    // У бота больше нет доступа к ревью
+    RemovalForbidden = "forbidden"
+    // Ревью на месте, но вышло из окна `lookback`
+    RemovalExpired = "expired"
+)
+
+/*
+    Настройки частоты опроса проектов. Интервал проекта — `pollInterval` из projectMap,
+    он меняется в зависимости от активности, времени суток и ошибок
+ */
+type PollingConfig struct {
+    // Сколько минут после изменения ревью опрашивать проект чаще, по умолчанию 30
+    ActiveWindow time.Duration `json:"activeWindow"`
+    // Во сколько раз чаще опрашивать активный проект, по умолчанию 2
+    ActiveFactor float64 `json:"activeFactor"`
+    // Тихие часы, например с 20 до 8, и выходные — опрос реже
+    QuietFrom int `json:"quietFrom"`
+    QuietTo int `json:"quietTo"`
+    QuietWeekends bool `json:"quietWeekends"`
+    Timezone string `json:"timezone"`
+    // Во сколько раз реже опрашивать в тихое время, по умолчанию 4
+    QuietFactor float64 `json:"quietFactor"`
+    // Предел интервала при ошибках Crucible, в минутах, по умолчанию 30
+    MaxBackoff time.Duration `json:"maxBackoff"`
+    // Случайный разброс интервала в процентах, по умолчанию 10, 0 — без разброса
+    Jitter *int `json:"jitter"`
+    // Опрашивать проекты с одинаковым `lookback` одним запросом без фильтра по проекту
+    Batch bool `json:"batch"`
+}
+
+func (config *PollingConfig) GetJitter() int {
+    if config.Jitter == nil {
+        return 10
+    }
+
+    return *config.Jitter
+}
+
+func (config *PollingConfig) IsQuiet(now time.Time) bool {
+    quiet := ReminderConfig{
+        QuietFrom: config.QuietFrom,
+        QuietTo: config.QuietTo,
+        SkipWeekends: config.QuietWeekends,
+        Timezone: config.Timezone,
+    }
+
+    return !quiet.IsWorkingTime(now)
+}
+
+/*
+    Интервал до следующего опроса проекта
+ */
+func (config *PollingConfig) Interval(base time.Duration, errors int, lastChange time.Time, now time.Time) time.Duration {
+    interval := base
+
+    switch {
+    case errors > 0:
+        // Удваиваем интервал после каждой ошибки подряд
+        maxBackoff := config.MaxBackoff * time.Minute
+        if maxBackoff <= 0 {
+            maxBackoff = 30 * time.Minute
+        }
+
+        for i := 0; i < errors && interval < maxBackoff; i++ {
+            interval *= 2
+        }
+
+        if interval > maxBackoff && base < maxBackoff {
+            interval = maxBackoff
+        }
+    case !lastChange.IsZero() && now.Sub(lastChange) < config.activeWindow():
+        factor := config.ActiveFactor
+        if factor <= 0 {
+            factor = 2
+        }
+
+        interval = time.Duration(float64(interval) / factor)
+    case config.IsQuiet(now):
+        factor := config.QuietFactor
+        if factor <= 0 {
+            factor = 4
+        }
+
+        interval = time.Duration(float64(interval) * factor)
+    }
+
+    if spread := int64(interval) * int64(config.GetJitter()) / 100; spread > 0 {
+        interval += time.Duration(rand.Int63n(2*spread+1) - spread)
+    }
+
+    if interval < time.Second {
+        interval = time.Second
+    }
+
+    return interval
+}
+
+func (config *PollingConfig) activeWindow() time.Duration {
+    if config.ActiveWindow > 0 {
+        return config.ActiveWindow * time.Minute
+    }
+
+    return 30 * time.Minute
+}
+
+/*
+    Состояние опроса одного проекта
+ */
+type projectPoller struct {
+    name string
+    // Ревью из последнего списка по PermaID
+    index crucible.ReviewIndex
+    // Ревью, пропавшие из списка раньше, чем вышли из окна `lookback`
+    missing map[string]crucible.Review
+    // Пропавшие ревью, которые ещё не удалось проверить отдельным запросом
+    unconfirmed map[string]bool
+    // Получен первый список, дальше по нему ищутся изменения
+    loaded bool
+    // Валидаторы последнего полученного списка, для условного запроса
+    validators crucible.Validators
+    comments *CommentWatcher
+    nextPoll time.Time
+    lastChange time.Time
+    // Ошибок подряд
+    errors int
+}
+
+func (poller *projectPoller) scheduleNext(now time.Time) {
+    config := CONFIG.Get().Polling
+    poller.nextPoll = now.Add(config.Interval(projectPollInterval(poller.name), poller.errors, poller.lastChange, now))
+    health.ProjectScheduled(poller.name, poller.nextPoll)
+}
+
+/*
+    Известное состояние ревью и изменилось ли оно. Пропавшее ранее ревью, которое вернулось
+    в список, сравнивается с тем, каким было, а не считается новым
+ */
+func (poller *projectPoller) previous(renewed crucible.Review) (old crucible.Review, changed bool) {
+    id := renewed.GetID()
+
+    if gone, ok := poller.missing[id]; ok {
+        delete(poller.missing, id)
+        delete(poller.unconfirmed, id)
+        log.Println("Ревью вернулось в список проекта", poller.name, id)
+        return gone, gone.Fingerprint() != renewed.Fingerprint()
+    }
+
+    old, _ = poller.index.Get(id)
+    return old, poller.index.Changed(renewed)
+}
+
+/*
+    Ревью было в прошлом списке, но не пришло в новом. Ревью старше окна `lookback`
+    просто перестали попадать в запрос, остальные, возможно, удалены или перенесены
+ */
+func (poller *projectPoller) removed(review crucible.Review, fromDate time.Time) {
+    if review.CreateDate.Before(fromDate) {
+        reviewsRemovedTotal.Inc(poller.name, RemovalExpired)
+        return
+    }
+
+    reviewsRemovedTotal.Inc(poller.name, "missing")
+    log.Println("Ревью пропало из списка проекта", poller.name, review.GetID(), review.GetState())
+    poller.missing[review.GetID()] = review
+    poller.unconfirmed[review.GetID()] = true
+}
+
+/*
+    Проверяет пропавшие ревью запросом одного ревью: удалено, перенесено, нет доступа
+    или просто вышло из окна запроса. Об удалении, переносе и потере доступа посылается
+    событие, при ошибке запроса проверка повторяется при следующем опросе
+ */
+func (supervisor *ProjectSupervisor) confirmRemoved(ctx context.Context, poller *projectPoller) {
+    ids := []string{}
+
+    for id := range poller.unconfirmed {
+        ids = append(ids, id)
+    }
+
+    sort.Strings(ids)
+
+    for _, id := range ids {
+        review := poller.missing[id]
+        fetched, err := supervisor.crucibleClient.GetReview(ctx, id)
+
+        if ctx.Err() != nil {
+            return
+        }
+
+        renewed := review
+        reason := ""
+
+        switch status := crucible.ErrorStatus(err); {
+        case err == nil && fetched.ProjectKey != "" && fetched.ProjectKey != poller.name:
+            reason = RemovalMoved
+            renewed = fetched
+        case err == nil:
+            // Ревью на месте, ждём его в списке, пока не выйдет из окна по дате создания
+            log.Println("Ревью вышло из окна запроса", poller.name, id)
+            reviewsRemovedTotal.Inc(poller.name, RemovalExpired)
+            delete(poller.unconfirmed, id)
+            continue
+        case status == http.StatusNotFound:
+            reason = RemovalDeleted
+        case status == http.StatusUnauthorized || status == http.StatusForbidden:
+            reason = RemovalForbidden
+        default:
+            log.Println("Не удалось проверить пропавшее ревью", poller.name, id, err)
+            continue
+        }
+
+        log.Println("Ревью больше нет в проекте", poller.name, id, reason)
+        reviewsRemovedTotal.Inc(poller.name, reason)
+        delete(poller.unconfirmed, id)
+        delete(poller.missing, id)
+
+        if reason == RemovalMoved {
+            reviewHistory.Update([]crucible.Review{renewed})
+        } else {
+            reviewHistory.Remove(id)
+        }
+
+        eventsTotal.Inc("review.removed")
+
+        supervisor.eventChannel <- ReviewEvent{
+            ProjectName: poller.name,
+            OldRev: review,
+            NewRev: renewed,
+            Removed: reason,
+        }
+    }
+}
+
+type projectUpdate struct {
+    projectName string
+    review crucible.Review
+}
+
+/*
+    Общий опрос всех проектов из `ProjectMap` в одной горутине. Проекты добавляются
+    и удаляются при изменении конфига
+ */
+type ProjectSupervisor struct {
+    mutex sync.Mutex
+    crucibleClient *crucible.Crucible
+    eventChannel chan ReviewEvent
+    commentChannel chan CommentEvent
+    projects map[string]*projectPoller
+    // Ревью, полученные по вызову из Crucible
+    updates chan projectUpdate
+    // Изменился список проектов
+    wake chan struct{}
+}
+
+func NewProjectSupervisor(ctx context.Context, crucibleClient *crucible.Crucible, eventChannel chan ReviewEvent, commentChannel chan CommentEvent, wg *sync.WaitGroup) *ProjectSupervisor {
+    supervisor := &ProjectSupervisor{
+        crucibleClient: crucibleClient,
+        eventChannel: eventChannel,
+        commentChannel: commentChannel,
+        projects: map[string]*projectPoller{},
+        updates: make(chan projectUpdate, 100),
+        wake: make(chan struct{}, 1),
+    }
+
+    wg.Add(1)
+    go supervisor.run(ctx, wg)
+
+    return supervisor
+}
+
+/*
+    Добавляет в опрос новые проекты и убирает удалённые
+ */
+func (supervisor *ProjectSupervisor) Sync(projectMap map[string]ProjectConfig) {
+    supervisor.mutex.Lock()
+    defer supervisor.mutex.Unlock()
+
+    for projectName := range supervisor.projects {
+        if _, ok := projectMap[projectName]; !ok {
+            log.Println("Отключаем проект", projectName)
+            delete(supervisor.projects, projectName)
+            health.RemoveProject(projectName)
+        }
+    }
+
+    projects := []string{}
+
+    for projectName := range projectMap {
+        if _, ok := supervisor.projects[projectName]; !ok {
+            projects = append(projects, projectName)
+        }
+    }
+
+    sort.Strings(projects)
+    now := time.Now()
+
+    for _, projectName := range projects {
+        log.Println("Подключаем проект", projectName)
+
+        // Первый опрос в случайный момент, чтобы проекты не опрашивались разом
+        delay := projectPollInterval(projectName)
+        if delay > maxStartupDelay {
+            delay = maxStartupDelay
+        }
+
+        poller := &projectPoller{
+            name: projectName,
+            missing: map[string]crucible.Review{},
+            unconfirmed: map[string]bool{},
+            comments: NewCommentWatcher(supervisor.crucibleClient),
+            nextPoll: now.Add(time.Duration(rand.Int63n(int64(delay) + 1))),
+        }
+
+        supervisor.projects[projectName] = poller
+        health.ProjectScheduled(projectName, poller.nextPoll)
+    }
+
+    select {
+    case supervisor.wake <- struct{}{}:
+    default:
+    }
+}
+
+/*
+    Передаёт свежее ревью опросу его проекта. false, если проект не отслеживается
+ */
+func (supervisor *ProjectSupervisor) Push(projectName string, review crucible.Review) bool {
+    supervisor.mutex.Lock()
+    _, ok := supervisor.projects[projectName]
+    supervisor.mutex.Unlock()
+
+    if !ok {
+        return false
+    }
+
+    select {
+    case supervisor.updates <- projectUpdate{projectName, review}:
+    default:
+        // Опрос занят, изменение найдётся при следующем опросе
+        log.Println("Очередь ревью проекта переполнена", projectName, review.GetID())
+    }
+
+    return true
+}
+
+func (supervisor *ProjectSupervisor) project(projectName string) (poller *projectPoller, ok bool) {
+    supervisor.mutex.Lock()
+    defer supervisor.mutex.Unlock()
+
+    poller, ok = supervisor.projects[projectName]
+    return
+}
+
+func (supervisor *ProjectSupervisor) untilNextPoll() time.Duration {
+    supervisor.mutex.Lock()
+    defer supervisor.mutex.Unlock()
+
+    next := time.Now().Add(time.Hour)
+
+    for _, poller := range supervisor.projects {
+        if poller.nextPoll.Before(next) {
+            next = poller.nextPoll
+        }
+    }
+
+    return time.Until(next)
+}
+
+func (supervisor *ProjectSupervisor) run(ctx context.Context, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    for {
+        timer := time.NewTimer(supervisor.untilNextPoll())
+
+        select {
+        case <-ctx.Done():
+            timer.Stop()
+            return
+        case <-supervisor.wake:
+            timer.Stop()
+            continue
+        case update := <-supervisor.updates:
+            timer.Stop()
+            supervisor.applyReview(ctx, update)
+            continue
+        case <-timer.C:
+        }
+
+        supervisor.pollDue(ctx)
+
+        if ctx.Err() != nil {
+            return
+        }
+    }
+}
+
+/*
+    Группы проектов для опроса: по проекту на запрос, а с `polling.batch` — все проекты
+    с одинаковым `lookback` одним запросом. В группу к проекту, которому пора, попадают
+    и те, кого пора опрашивать в ближайшие полинтервала
+ */
+func (supervisor *ProjectSupervisor) dueGroups(now time.Time) (groups [][]*projectPoller) {
+    supervisor.mutex.Lock()
+    defer supervisor.mutex.Unlock()
+
+    names := []string{}
+
+    for projectName := range supervisor.projects {
+        names = append(names, projectName)
+    }
+
+    sort.Strings(names)
+
+    if !CONFIG.Get().Polling.Batch {
+        for _, projectName := range names {
+            if poller := supervisor.projects[projectName]; !poller.nextPoll.After(now) {
+                groups = append(groups, []*projectPoller{poller})
+            }
+        }
+
+        return
+    }
+
+    byLookback := map[int][]*projectPoller{}
+    lookbacks := []int{}
+    due := map[int]bool{}
+
+    for _, projectName := range names {
+        poller := supervisor.projects[projectName]
+        lookback := CONFIG.Get().Project(projectName).Lookback
+
+        if !poller.nextPoll.After(now.Add(projectPollInterval(projectName) / 2)) {
+            if _, ok := byLookback[lookback]; !ok {
+                lookbacks = append(lookbacks, lookback)
+            }
+
+            byLookback[lookback] = append(byLookback[lookback], poller)
+        }
+
+        due[lookback] = due[lookback] || !poller.nextPoll.After(now)
+    }
+
+    for _, lookback := range lookbacks {
+        if due[lookback] {
+            groups = append(groups, byLookback[lookback])
+        }
+    }
+
+    return
+}
+
+/*
+    Валидаторы для условного запроса группы: только если все проекты группы
+    получили один и тот же последний список
+ */
+func groupValidators(group []*projectPoller) crucible.Validators {
+    for _, poller := range group {
+        if !poller.loaded || poller.validators != group[0].validators {
+            return crucible.Validators{}
+        }
+    }
+
+    return group[0].validators
+}
+
+func (supervisor *ProjectSupervisor) pollDue(ctx context.Context) {
+    for _, group := range supervisor.dueGroups(time.Now()) {
+        lookback := CONFIG.Get().Project(group[0].name).Lookback
+        options := crucible.GetReviewsOptions{
+            // Начало окна округляется до часа, чтобы запрос не менялся и работали условные запросы
+            FromDate: time.Now().AddDate(0, 0, -lookback).Truncate(time.Hour),
+            Validators: groupValidators(group),
+        }
+
+        if len(group) == 1 {
+            options.Project = group[0].name
+        }
+
+        started := time.Now()
+        update, err := supervisor.crucibleClient.GetReviews(ctx, options)
+        duration := time.Since(started)
+
+        // Бот останавливается
+        if ctx.Err() != nil {
+            return
+        }
+
+        for _, poller := range group {
+            // Проект отключили во время запроса
+            if current, ok := supervisor.project(poller.name); !ok || current != poller {
+                continue
+            }
+
+            projectReviews := update
+
+            switch {
+            case err == nil && update.NotModified:
+                // Список не изменился, сверяем с ним же: комментарии и пропавшие ревью проверяются как обычно
+                pollNotModifiedTotal.Inc(poller.name)
+                projectReviews = crucible.ReviewList{Reviews: poller.index.Reviews()}
+            case len(group) > 1:
+                projectReviews = crucible.ReviewList{Reviews: update.Filter(func(review crucible.Review) bool {
+                    return review.ProjectKey == poller.name
+                })}
+            }
+
+            supervisor.apply(ctx, poller, projectReviews, err, duration)
+
+            if err == nil {
+                poller.validators = update.Validators
+            }
+
+            supervisor.mutex.Lock()
+            poller.scheduleNext(time.Now())
+            supervisor.mutex.Unlock()
+        }
+    }
+}
+
+/*
+    Сравнивает полученный список ревью проекта с предыдущим и посылает события об изменениях
+ */
+func (supervisor *ProjectSupervisor) apply(ctx context.Context, poller *projectPoller, update crucible.ReviewList, err error, duration time.Duration) {
+    projectName := poller.name
+
+    pollDuration.Observe(duration.Seconds(), projectName)
+    health.ProjectPolled(projectName, len(update.Reviews), err)
+
+    if err != nil {
+        pollErrorsTotal.Inc(projectName)
+
+        if poller.errors == 0 {
+            log.Println("Ошибка обновления списка review", projectName, err)
+        }
+
+        poller.errors++
+        return
+    } else if poller.errors > 0 {
+        log.Println("Успешно обновлён список review после ошибки", projectName)
+        poller.errors = 0
+    }
+
+    if !poller.loaded {
+        log.Println("Получили список ревью", projectName, len(update.Reviews))
+
+        reviewHistory.Update(update.Reviews)
+        trackReviews(projectName, update.Reviews)
+        poller.comments.Poll(ctx, projectName, update.Reviews, supervisor.commentChannel)
+
+        poller.index = crucible.NewReviewIndex(update.Reviews)
+        poller.loaded = true
+        return
+    }
+
+    if len(update.Reviews) == 0 {
+        // Пустой список ревью
+        log.Println("Пришел пустой список ревью", projectName)
+        return
+    }
+
+    changed := false
+
+    for _, renewed := range update.Reviews {
+        old, reviewChanged := poller.previous(renewed)
+
+        if reviewChanged {
+            emitReviewUpdate(projectName, old, renewed, supervisor.eventChannel)
+            changed = true
+        }
+    }
+
+    current := crucible.NewReviewIndex(update.Reviews)
+    fromDate := time.Now().AddDate(0, 0, -CONFIG.Get().Project(projectName).Lookback)
+
+    for _, review := range poller.index.Missing(current) {
+        poller.removed(review, fromDate)
+    }
+
+    // Пропавшие ревью, которые уже вышли из окна, больше не ждём
+    for id, review := range poller.missing {
+        if review.CreateDate.Before(fromDate) {
+            delete(poller.missing, id)
+            delete(poller.unconfirmed, id)
+        }
+    }
+
+    supervisor.confirmRemoved(ctx, poller)
+
+    reviewHistory.Update(update.Reviews)
+    trackReviews(projectName, update.Reviews)
+
+    if changed {
+        poller.lastChange = time.Now()
+
+        if err := reviewHistory.Save(); err != nil {
+            log.Println("Ошибка сохранения истории ревью", err)
+        }
+    }
+
+    poller.comments.Poll(ctx, projectName, update.Reviews, supervisor.commentChannel)
+    poller.index = current
+}
+
+/*
+    Ревью, полученное по вызову из Crucible, обрабатывается сразу, не дожидаясь опроса.
+    До первого списка проекта сравнивать не с чем, такое ревью найдётся при опросе
+ */
+func (supervisor *ProjectSupervisor) applyReview(ctx context.Context, update projectUpdate) {
+    poller, ok := supervisor.project(update.projectName)
+
+    if !ok || !poller.loaded {
+        return
+    }
+
+    renewed := update.review
+    old, changed := poller.previous(renewed)
+
+    if changed {
+        emitReviewUpdate(poller.name, old, renewed, supervisor.eventChannel)
+        poller.index.Set(renewed)
+        reviewHistory.Update([]crucible.Review{renewed})
+        poller.lastChange = time.Now()
+
+        if err := reviewHistory.Save(); err != nil {
+            log.Println("Ошибка сохранения истории ревью", err)
+        }
+    }
+
+    if renewed.GetState().IsActive() {
+        poller.comments.PollReview(ctx, poller.name, renewed, supervisor.commentChannel)
+    }
+}
+
+func validatePolling(errs *ConfigErrors, config PollingConfig) {
+    if config.ActiveWindow < 0 {
+        errs.Add("polling.activeWindow", "не может быть отрицательным")
+    }
+
+    if config.ActiveFactor < 0 {
+        errs.Add("polling.activeFactor", "не может быть отрицательным")
+    }
+
+    if config.QuietFactor < 0 {
+        errs.Add("polling.quietFactor", "не может быть отрицательным")
+    }
+
+    if config.MaxBackoff < 0 {
+        errs.Add("polling.maxBackoff", "не может быть отрицательным")
+    }
+
+    if jitter := config.GetJitter(); jitter < 0 || jitter > 100 {
+        errs.Add("polling.jitter", "процент от 0 до 100")
+    }
+
+    if config.QuietFrom < 0 || config.QuietFrom > 23 {
+        errs.Add("polling.quietFrom", "час от 0 до 23")
+    }
+
+    if config.QuietTo < 0 || config.QuietTo > 23 {
+        errs.Add("polling.quietTo", "час от 0 до 23")
+    }
+
+    validateTimezone(errs, "polling.timezone", config.Timezone)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+    "./crucible"
+    "encoding/json"
+    "strings"
+    "time"
+)
+
+// За сколько дней запрашивать ревью проекта по умолчанию
+const defaultLookback = 7
+
+// Типы уведомлений, которые можно включать для проекта
+const (
+    EventOpened = "opened"
+    EventCompleted = "completed"
+    EventComment = "comment"
+    EventReminder = "reminder"
+    // Ревью удалено, перенесено или недоступно боту, только если явно указано в `events`
+    EventRemoved = "removed"
+)
+
+var projectEvents = []string{EventOpened, EventCompleted, EventComment, EventReminder, EventRemoved}
+
+// Тексты уведомлений по умолчанию: {author} — автор, {reviewers} — ревьюверы, {title} — название ревью
+var defaultTemplates = map[string]string{
+    EventOpened: "{reviewers} нужно ревью",
+    EventCompleted: "{author} ревью завершен",
+    EventRemoved: "{author} ревью {title} пропало из Crucible",
+}
+
+// Как упоминать людей в сообщениях проекта
+const (
+    MentionAll = "all"
+    MentionNone = "none"
+)
+
+/*
+    Настройки проекта. В конфиге можно указать просто канал строкой: "CR": "channel"
+ */
+type ProjectConfig struct {
+    // Канал уведомлений, если не указан — служебный канал
+    Channel string `json:"channel"`
+    // Дополнительные каналы, куда дублируются уведомления о ревью
+    Channels []string `json:"channels"`
+    // Канал проекта в Mattermost, если не указан — служебный канал `mattermost.channel`
+    MattermostChannel string `json:"mattermostChannel"`
+    // Интервал опроса в секундах, по умолчанию crucible.timeout
+    PollInterval time.Duration `json:"pollInterval"`
+    // За сколько дней запрашивать ревью, по умолчанию неделя
+    Lookback int `json:"lookback"`
+    // Сколько ревьюверов должны завершить ревью, 0 — по умолчанию, -1 — все ревьюверы
+    CompletedReviewers int `json:"completedReviewers"`
+    // Какие уведомления отправлять: opened, completed, comment, reminder, removed; пусто — все, кроме removed
+    Events []string `json:"events"`
+    // Тексты уведомлений opened, completed и removed: {author}, {reviewers}, {title}
+    Templates map[string]string `json:"templates"`
+    // Напоминания проекта, незаданные поля берутся из reminders
+    Reminders ProjectReminderConfig `json:"reminders"`
+    // Как упоминать людей: all — @ник (по умолчанию), none — только имена, без уведомлений
+    Mentions string `json:"mentions"`
+}
+
+type ProjectReminderConfig struct {
+    After time.Duration `json:"after"`
+    EscalateAfter time.Duration `json:"escalateAfter"`
+    Repeat time.Duration `json:"repeat"`
+    // Кого звать при эскалации, вместо reminders.leads
+    Lead string `json:"lead"`
+}
+
+func (project *ProjectConfig) UnmarshalJSON(data []byte) error {
+    var channel string
+
+    // Старый формат: проект -> канал
+    if json.Unmarshal(data, &channel) == nil {
+        *project = ProjectConfig{Channel: channel}
+        return nil
+    }
+
+    type plain ProjectConfig
+    return json.Unmarshal(data, (*plain)(project))
+}
+
+/*
+    Настройки проекта с подставленными значениями по умолчанию
+ */
+func (config *Config) Project(projectName string) ProjectConfig {
+    project := config.ProjectMap[projectName]
+
+    if project.PollInterval <= 0 {
+        project.PollInterval = config.Crucible.Timeout
+    }
+
+    if project.Lookback <= 0 {
+        project.Lookback = defaultLookback
+    }
+
+    if project.CompletedReviewers == 0 {
+        project.CompletedReviewers = crucible.CompletedReviewersRequired
+    }
+
+    if project.Mentions == "" {
+        project.Mentions = MentionAll
+    }
+
+    return project
+}
+
+/*
+    Конфиг, с которым работает бот: у каждого проекта подставлены значения по умолчанию,
+    каналы, включённые уведомления, тексты и напоминания
+ */
+func effectiveConfig(config Config) Config {
+    projects := make(map[string]ProjectConfig, len(config.ProjectMap))
+
+    for projectName := range config.ProjectMap {
+        project := config.Project(projectName)
+
+        if project.Channel == "" {
+            project.Channel = config.Slack.ChannelName()
+        }
+
+        if project.MattermostChannel == "" {
+            project.MattermostChannel = config.Mattermost.Channel
+        }
+
+        events := []string{}
+
+        for _, event := range projectEvents {
+            if project.EventEnabled(event) {
+                events = append(events, event)
+            }
+        }
+
+        project.Events = events
+
+        templates := map[string]string{}
+
+        for event := range defaultTemplates {
+            templates[event] = project.Template(event)
+        }
+
+        project.Templates = templates
+
+        reminders := config.Reminders.ForProject(projectName, project)
+        project.Reminders = ProjectReminderConfig{
+            After: reminders.After,
+            EscalateAfter: reminders.EscalateAfter,
+            Repeat: reminders.Repeat,
+            Lead: reminders.EscalationTarget(projectName),
+        }
+
+        projects[projectName] = project
+    }
+
+    config.ProjectMap = projects
+    return config
+}
+
+/*
+    Все каналы уведомлений проекта: основной и дополнительные
+ */
+func (project *ProjectConfig) AllChannels(mainChannel string) []string {
+    channels := []string{mainChannel}
+
+    for _, channel := range project.Channels {
+        if channel != "" && channel != mainChannel {
+            channels = append(channels, channel)
+        }
+    }
+
+    return channels
+}
+
+func (project *ProjectConfig) EventEnabled(event string) bool {
+    if len(project.Events) == 0 {
+        return event != EventRemoved
+    }
+
+    for _, enabled := range project.Events {
+        if enabled == event {
+            return true
+        }
+    }
+
+    return false
+}
+
+func (project *ProjectConfig) Template(event string) string {
+    if template, ok := project.Templates[event]; ok && template != "" {
+        return template
+    }
+
+    return defaultTemplates[event]
+}
+
+/*
+    Текст по шаблону и автор с никами чата `kind`: slack или mattermost
+ */
+func (project *ProjectConfig) RenderChatTemplate(kind string, template string, review crucible.Review, title string) (text string, author string) {
+    author = project.MapChatNicks(kind, []string{review.GetAuthorNick()})
+    reviewers := project.MapChatNicks(kind, review.GetReviewersNames())
+
+    return renderTemplate(template, author, reviewers, title), author
+}
+
+func renderTemplate(template string, author string, reviewers string, title string) string {
+    return strings.NewReplacer("{author}", author, "{reviewers}", reviewers, "{title}", title).Replace(template)
+}
+
+func (project *ProjectConfig) IsCompleted(review crucible.Review) bool {
+    return review.IsCompletedBy(project.CompletedReviewers)
+}
+
+/*
+    Канал проекта в Mattermost, если не указан — служебный канал `mattermost.channel`
+ */
+func (project *ProjectConfig) GetMattermostChannel() string {
+    if project.MattermostChannel != "" {
+        return project.MattermostChannel
+    }
+
+    return CONFIG.Get().Mattermost.Channel
+}
+
+/*
+    Упоминание пользователей Crucible в сообщениях проекта с учётом `mentions`
+ */
+func (project *ProjectConfig) MapUserNicks(names []string) string {
+    return project.MapChatNicks(NotifierSlack, names)
+}
+
+/*
+    Ники в чате `kind`: slack или mattermost, с упоминанием, если оно не выключено в проекте
+ */
+func (project *ProjectConfig) MapChatNicks(kind string, names []string) string {
+    return mapChatNicks(kind, names, project.Mentions != MentionNone)
+}
+
+/*
+    Напоминания для проекта: общие настройки, переопределённые настройками проекта
+ */
+func (config ReminderConfig) ForProject(projectName string, project ProjectConfig) ReminderConfig {
+    if project.Reminders.After > 0 {
+        config.After = project.Reminders.After
+    }
+
+    if project.Reminders.EscalateAfter > 0 {
+        config.EscalateAfter = project.Reminders.EscalateAfter
+    }
+
+    if project.Reminders.Repeat > 0 {
+        config.Repeat = project.Reminders.Repeat
+    }
+
+    if project.Reminders.Lead != "" {
+        leads := map[string]string{projectName: project.Reminders.Lead}
+
+        for name, lead := range config.Leads {
+            if name != projectName {
+                leads[name] = lead
+            }
+        }
+
+        config.Leads = leads
+    }
+
+    return config
+}
+
+/*
+    Включены ли напоминания хотя бы для одного проекта
+ */
+func (config *Config) RemindersEnabled() bool {
+    if config.Reminders.Enabled() {
+        return true
+    }
+
+    for _, project := range config.ProjectMap {
+        if project.Reminders.After > 0 {
+            return true
+        }
+    }
+
+    return false
+}
+
+func validateProject(errs *ConfigErrors, projectName string, project ProjectConfig) {
+    path := "projectMap." + projectName
+
+    if project.PollInterval < 0 {
+        errs.Add(path+".pollInterval", "не может быть отрицательным")
+    }
+
+    if project.Lookback < 0 {
+        errs.Add(path+".lookback", "не может быть отрицательным")
+    }
+
+    if project.CompletedReviewers < -1 {
+        errs.Add(path+".completedReviewers", "число ревьюверов или -1 — все ревьюверы")
+    }
+
+    for _, event := range project.Events {
+        if !containsString(projectEvents, event) {
+            errs.Add(path+".events", "неизвестный тип уведомлений %q, возможны: %s", event, strings.Join(projectEvents, ", "))
+        }
+    }
+
+    for event := range project.Templates {
+        if _, ok := defaultTemplates[event]; !ok {
+            errs.Add(path+".templates."+event, "шаблон есть только для opened, completed и removed")
+        }
+    }
+
+    if project.Mentions != "" && project.Mentions != MentionAll && project.Mentions != MentionNone {
+        errs.Add(path+".mentions", "возможны all или none, указано %q", project.Mentions)
+    }
+
+    if project.Reminders.After < 0 || project.Reminders.EscalateAfter < 0 || project.Reminders.Repeat < 0 {
+        errs.Add(path+".reminders", "интервалы не могут быть отрицательными")
+    }
+}
+
+func containsString(list []string, value string) bool {
+    for _, item := range list {
+        if item == value {
+            return true
+        }
+    }
+
+    return false
+}
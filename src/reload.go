@@ -0,0 +1,124 @@
+package main
+
+import (
+    "context"
+    "log"
+    "os"
+    "os/signal"
+    "reflect"
+    "sync"
+    "syscall"
+    "time"
+)
+
+// Как часто проверять изменение файла конфига
+const configCheckInterval = 5 * time.Second
+
+/*
+    Текущий конфиг бота, заменяется целиком при перечитывании файла.
+    Полученный через Get конфиг нельзя изменять
+ */
+type ConfigHolder struct {
+    mutex sync.RWMutex
+    config *Config
+}
+
+func (holder *ConfigHolder) Get() *Config {
+    holder.mutex.RLock()
+    defer holder.mutex.RUnlock()
+
+    if holder.config == nil {
+        return &Config{}
+    }
+
+    return holder.config
+}
+
+func (holder *ConfigHolder) Set(config Config) {
+    holder.mutex.Lock()
+    defer holder.mutex.Unlock()
+
+    holder.config = &config
+}
+
+/*
+    Перечитывает конфиг при изменении файла и по SIGHUP. Проекты, пользователи и каналы
+    применяются сразу, остальные настройки — после перезапуска
+ */
+func watchConfig(ctx context.Context, path string, supervisor *ProjectSupervisor, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+
+    modified := configModTime(path)
+    ticker := time.NewTicker(configCheckInterval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-hup:
+            log.Println("Получен SIGHUP, перечитываем конфиг")
+        case <-ticker.C:
+            current := configModTime(path)
+
+            if current.Equal(modified) {
+                continue
+            }
+
+            log.Println("Файл конфига изменён, перечитываем", path)
+        }
+
+        modified = configModTime(path)
+        reloadConfig(path, supervisor)
+    }
+}
+
+func configModTime(path string) time.Time {
+    info, err := os.Stat(path)
+
+    if err != nil {
+        return time.Time{}
+    }
+
+    return info.ModTime()
+}
+
+func reloadConfig(path string, supervisor *ProjectSupervisor) {
+    config, err := getConfig(path)
+
+    if err != nil {
+        log.Println("Ошибка в конфиге, оставляем прежний:", err)
+        return
+    }
+
+    old := CONFIG.Get()
+
+    restart := map[string]bool{
+        "crucible": !reflect.DeepEqual(old.Crucible, config.Crucible),
+        "slack": !reflect.DeepEqual(old.Slack, config.Slack),
+        "reminders": !reflect.DeepEqual(old.Reminders, config.Reminders),
+        "digests": !reflect.DeepEqual(old.Digests, config.Digests),
+        "history": !reflect.DeepEqual(old.History, config.History),
+        "stats": !reflect.DeepEqual(old.Stats, config.Stats),
+        "http": !reflect.DeepEqual(old.HTTP, config.HTTP),
+        "mattermost": !reflect.DeepEqual(old.Mattermost, config.Mattermost),
+        "mailDigest": !reflect.DeepEqual(old.MailDigest, config.MailDigest),
+        "hooks": !reflect.DeepEqual(old.Hooks, config.Hooks),
+        "triggers": old.Triggers.Path != config.Triggers.Path,
+    }
+
+    for name, changed := range restart {
+        if changed {
+            log.Println("Изменения в разделе", name, "применятся после перезапуска")
+        }
+    }
+
+    CONFIG.Set(config)
+    supervisor.Sync(config.ProjectMap)
+
+    log.Println("Конфиг применён")
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+    "./crucible"
+    "./slack"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+type ReminderConfig struct {
+    // Через сколько часов после создания ревью напоминать ревьюверам, 0 — напоминания выключены
+    After time.Duration `json:"after"`
+    // Через сколько часов звать лида
+    EscalateAfter time.Duration `json:"escalateAfter"`
+    // Не чаще чем раз в столько часов для одного ревью, по умолчанию раз в сутки
+    Repeat time.Duration `json:"repeat"`
+    // Как часто проверять ревью, в минутах
+    Interval time.Duration `json:"interval"`
+    // Кого звать при эскалации: канал (#channel) или пользователь, для всех проектов
+    EscalateTo string `json:"escalateTo"`
+    // Лиды проектов: проект -> канал (#channel) или пользователь
+    Leads map[string]string `json:"leads"`
+    // Тихие часы, например с 20 до 10
+    QuietFrom int `json:"quietFrom"`
+    QuietTo int `json:"quietTo"`
+    SkipWeekends bool `json:"skipWeekends"`
+    Timezone string `json:"timezone"`
+    // Файл, в котором хранится когда и о чём напоминали
+    StateFile string `json:"stateFile"`
+}
+
+func (config *ReminderConfig) Enabled() bool {
+    return config.After > 0
+}
+
+func (config *ReminderConfig) Location() *time.Location {
+    if config.Timezone == "" {
+        return time.Local
+    }
+
+    location, err := time.LoadLocation(config.Timezone)
+
+    if err != nil {
+        log.Println("Неизвестная временная зона", config.Timezone, err)
+        return time.Local
+    }
+
+    return location
+}
+
+/*
+    Можно ли сейчас беспокоить людей: не тихие часы и не выходные
+ */
+func (config *ReminderConfig) IsWorkingTime(now time.Time) bool {
+    now = now.In(config.Location())
+
+    if config.SkipWeekends && (now.Weekday() == time.Saturday || now.Weekday() == time.Sunday) {
+        return false
+    }
+
+    if config.QuietFrom == config.QuietTo {
+        return true
+    }
+
+    hour := now.Hour()
+
+    if config.QuietFrom < config.QuietTo {
+        return hour < config.QuietFrom || hour >= config.QuietTo
+    }
+
+    // Тихие часы через полночь
+    return hour < config.QuietFrom && hour >= config.QuietTo
+}
+
+/*
+    Кого звать при эскалации по проекту
+ */
+func (config *ReminderConfig) EscalationTarget(projectName string) string {
+    if lead, ok := config.Leads[projectName]; ok {
+        return lead
+    }
+
+    return config.EscalateTo
+}
+
+type ReminderState struct {
+    LastReminded time.Time `json:"lastReminded"`
+    Count int `json:"count"`
+    Escalated bool `json:"escalated"`
+}
+
+/*
+    Состояние напоминаний по ревью, сохраняется в файл, чтобы не повторять напоминания после перезапуска
+ */
+type ReminderStore struct {
+    mutex sync.Mutex
+    path string
+    reviews map[string]ReminderState
+}
+
+func LoadReminderStore(path string) (store *ReminderStore, err error) {
+    store = &ReminderStore{path: path, reviews: map[string]ReminderState{}}
+
+    if path == "" {
+        return
+    }
+
+    data, err := ioutil.ReadFile(path)
+
+    if os.IsNotExist(err) {
+        return store, nil
+    }
+
+    if err != nil {
+        return
+    }
+
+    err = json.Unmarshal(data, &store.reviews)
+    return
+}
+
+func (store *ReminderStore) Get(reviewID string) ReminderState {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+
+    return store.reviews[reviewID]
+}
+
+func (store *ReminderStore) Set(reviewID string, state ReminderState) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+
+    store.reviews[reviewID] = state
+}
+
+/*
+    Удаляет состояние ревью, которых нет в `keep`
+ */
+func (store *ReminderStore) Retain(keep map[string]bool) {
+    store.mutex.Lock()
+    defer store.mutex.Unlock()
+
+    for reviewID := range store.reviews {
+        if !keep[reviewID] {
+            delete(store.reviews, reviewID)
+        }
+    }
+}
+
+func (store *ReminderStore) Save() (err error) {
+    if store.path == "" {
+        return
+    }
+
+    store.mutex.Lock()
+    data, err := json.MarshalIndent(store.reviews, "", "  ")
+    store.mutex.Unlock()
+
+    if err != nil {
+        return
+    }
+
+    return ioutil.WriteFile(store.path, data, 0644)
+}
+
+/*
+    Периодически проверяет ревью всех проектов и напоминает ревьюверам о зависших ревью
+ */
+func watchReminders(ctx context.Context, crucibleClient *crucible.Crucible, slackClient slack.SlackClient, config ReminderConfig, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    store, err := LoadReminderStore(config.StateFile)
+
+    if err != nil {
+        log.Println("Ошибка чтения состояния напоминаний", config.StateFile, err)
+    }
+
+    interval := config.Interval * time.Minute
+    if interval <= 0 {
+        interval = 15 * time.Minute
+    }
+
+    for {
+        if config.IsWorkingTime(time.Now()) {
+            active := map[string]bool{}
+            complete := true
+
+            for projectName := range CONFIG.Get().ProjectMap {
+                project := CONFIG.Get().Project(projectName)
+                projectConfig := config.ForProject(projectName, project)
+
+                if !projectConfig.Enabled() || !project.EventEnabled(EventReminder) {
+                    continue
+                }
+
+                err := remindProject(ctx, projectName, project, crucibleClient, slackClient, projectConfig, store, active)
+
+                if err != nil {
+                    log.Println("Ошибка получения ревью для напоминаний", projectName, err)
+                    complete = false
+                }
+            }
+
+            // Если список ревью получен не полностью, состояние не чистим
+            if complete {
+                store.Retain(active)
+            }
+
+            if err := store.Save(); err != nil {
+                log.Println("Ошибка сохранения состояния напоминаний", err)
+            }
+        }
+
+        if !sleepContext(ctx, interval) {
+            return
+        }
+    }
+}
+
+func remindProject(ctx context.Context, projectName string, project ProjectConfig, crucibleClient *crucible.Crucible, slackClient slack.SlackClient, config ReminderConfig, store *ReminderStore, active map[string]bool) (err error) {
+    reviews, err := crucibleClient.GetReviews(ctx, crucible.GetReviewsOptions{
+        Project: projectName,
+        States: []crucible.State{crucible.StateReview},
+    })
+
+    if err != nil {
+        return
+    }
+
+    repeat := config.Repeat * time.Hour
+    if repeat <= 0 {
+        repeat = 24 * time.Hour
+    }
+
+    for _, review := range reviews.Reviews {
+        active[review.GetID()] = true
+
+        age := review.Age()
+        pending := review.GetPendingReviewersNames()
+
+        if age < config.After*time.Hour || len(pending) == 0 || project.IsCompleted(review) {
+            continue
+        }
+
+        state := store.Get(review.GetID())
+
+        if !state.LastReminded.IsZero() && time.Since(state.LastReminded) < repeat {
+            continue
+        }
+
+        // Руководителю пишем один раз, повторные напоминания идут только ревьюверам
+        escalate := !state.Escalated && config.EscalateAfter > 0 && age >= config.EscalateAfter*time.Hour
+
+        err := postReminder(ctx, slackClient, projectName, project, review, pending, escalate, config)
+
+        if err != nil {
+            log.Println("Ошибка отправки напоминания", review.GetID(), err)
+            continue
+        }
+
+        state.LastReminded = time.Now()
+        state.Count++
+        state.Escalated = state.Escalated || escalate
+        store.Set(review.GetID(), state)
+    }
+
+    return
+}
+
+func postReminder(ctx context.Context, slackClient slack.SlackClient, projectName string, project ProjectConfig, review crucible.Review, pending []string, escalate bool, config ReminderConfig) (err error) {
+    text := fmt.Sprintf("%s ревью ждёт вас уже %s", project.MapUserNicks(pending), formatDuration(review.Age()))
+
+    target := config.EscalationTarget(projectName)
+    escalateChannel := ""
+
+    if escalate && target != "" {
+        if strings.HasPrefix(target, "#") {
+            escalateChannel = target
+        } else {
+            text = fmt.Sprintf("%s\n%s, ревью висит дольше %s", text, project.MapUserNicks([]string{strings.TrimPrefix(target, "@")}), formatDuration(config.EscalateAfter*time.Hour))
+        }
+    }
+
+    attachment := slack.Attachment{
+        Title:      review.Name,
+        TitleLink:  review.GetURL(CONFIG.Get().Crucible.Host),
+        AuthorName: project.MapUserNicks([]string{review.GetAuthorNick()}),
+        Text:       reviewProgress(NotifierSlack, review),
+        Color:      "warning",
+    }
+
+    if attachment.Title == "" {
+        attachment.Title = review.GetID()
+    }
+
+    if escalate {
+        attachment.Color = "danger"
+    }
+
+    message := slack.Message{
+        Text: text,
+        Channel: projectChannel(projectName),
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    if thread, ok := reviewThreads.Get(review.GetID()); ok {
+        message.Channel = thread.Channel
+        message.ThreadTs = thread.Ts
+    }
+
+    message.AddAttachment(attachment)
+
+    _, err = slackClient.PostMessage(ctx, message)
+
+    if err != nil || escalateChannel == "" {
+        return
+    }
+
+    escalation := slack.Message{
+        Text: fmt.Sprintf("Ревью проекта %s висит уже %s, ждём: %s", projectName, formatDuration(review.Age()), project.MapUserNicks(pending)),
+        Channel: strings.TrimPrefix(escalateChannel, "#"),
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    escalation.AddAttachment(attachment)
+
+    _, err = slackClient.PostMessage(ctx, escalation)
+    return
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+    "./crucible"
+    "./schedule"
+    "./slack"
+    "./stats"
+    "context"
+    "fmt"
+    "log"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+type StatsConfig struct {
+    // Расписание еженедельного отчёта в формате cron, пусто — отчёт не отправляется
+    Schedule string `json:"schedule"`
+    Timezone string `json:"timezone"`
+    // Канал для отчёта по всем проектам, если не указан — отчёт каждого проекта уходит в его канал
+    Channel string `json:"channel"`
+    // Период отчёта в днях, по умолчанию неделя
+    Days int `json:"days"`
+}
+
+var statsPeriodRe = regexp.MustCompile(`^(\d+)([dwm])$`)
+
+/*
+    Период вида 7d, 2w, 1m, week или month в днях
+ */
+func parseStatsPeriod(value string) (days int, ok bool) {
+    switch value {
+    case "week":
+        return 7, true
+    case "month":
+        return 30, true
+    }
+
+    match := statsPeriodRe.FindStringSubmatch(value)
+
+    if match == nil {
+        return
+    }
+
+    days, err := strconv.Atoi(match[1])
+
+    if err != nil || days <= 0 {
+        return 0, false
+    }
+
+    switch match[2] {
+    case "w":
+        days *= 7
+    case "m":
+        days *= 30
+    }
+
+    return days, true
+}
+
+/*
+    Ревью проекта для статистики: история бота, дополненная свежими данными из Crucible
+ */
+func collectReviews(ctx context.Context, crucibleClient *crucible.Crucible, projectName string, from time.Time) (reviews []crucible.Review, err error) {
+    fresh, err := crucibleClient.GetReviews(ctx, crucible.GetReviewsOptions{
+        Project: projectName,
+        // Ревью, закрытые за период, могли быть созданы раньше
+        FromDate: from.AddDate(0, 0, -30),
+    })
+
+    if err != nil {
+        return
+    }
+
+    byID := map[string]crucible.Review{}
+
+    for _, review := range reviewHistory.Reviews(projectName) {
+        byID[review.GetID()] = review
+    }
+
+    for _, review := range fresh.Reviews {
+        byID[review.GetID()] = review
+    }
+
+    for _, review := range byID {
+        reviews = append(reviews, review)
+    }
+
+    return
+}
+
+func statsAttachment(report stats.Report) slack.Attachment {
+    attachment := slack.Attachment{
+        Title: fmt.Sprintf("%s: %s — %s", report.Project, report.From.Format("02.01.2006"), report.To.Format("02.01.2006")),
+        Color: "#439FE0",
+        Fields: []slack.AttachmentField{
+            {Title: "Создано", Value: strconv.Itoa(report.Opened), Short: true},
+            {Title: "Закрыто", Value: strconv.Itoa(report.Closed), Short: true},
+            {Title: "Брошено", Value: strconv.Itoa(report.Abandoned), Short: true},
+            {Title: "Медиана до завершения", Value: "—", Short: true},
+        },
+    }
+
+    if report.MedianCompletion > 0 {
+        attachment.Fields[3].Value = formatDuration(report.MedianCompletion)
+    }
+
+    lines := []string{}
+
+    for _, reviewer := range report.Reviewers {
+        line := fmt.Sprintf("%s: завершил %d", MapUserNicks([]string{reviewer.UserName}), reviewer.Completed)
+
+        if reviewer.MedianLatency > 0 {
+            line += fmt.Sprintf(", медиана %s", formatDuration(reviewer.MedianLatency))
+        }
+
+        if reviewer.Pending > 0 {
+            line += fmt.Sprintf(", ждут %d", reviewer.Pending)
+        }
+
+        lines = append(lines, line)
+    }
+
+    if len(lines) > 0 {
+        attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+            Title: "Ревьюверы",
+            Value: strings.Join(lines, "\n"),
+        })
+    }
+
+    return attachment
+}
+
+/*
+    Отправляет статистику по проектам за `days` дней в канал
+ */
+func postStats(ctx context.Context, crucibleClient *crucible.Crucible, poster MessagePoster, channel string, projects []string, days int) (err error) {
+    to := time.Now()
+    from := to.AddDate(0, 0, -days)
+
+    message := slack.Message{
+        Text: fmt.Sprintf("Статистика ревью за %d дн.", days),
+        Channel: channel,
+        IconUrl: "http://lorempixel.com/48/48/cats/",
+        AsUser: false,
+    }
+
+    for _, projectName := range projects {
+        reviews, err := collectReviews(ctx, crucibleClient, projectName, from)
+
+        if err != nil {
+            return err
+        }
+
+        message.AddAttachment(statsAttachment(stats.Compute(projectName, CONFIG.Get().Project(projectName).CompletedReviewers, reviews, from, to)))
+    }
+
+    _, err = poster.PostMessage(ctx, message)
+    return
+}
+
+/*
+    Еженедельный отчёт со статистикой ревью по расписанию
+ */
+func watchStats(ctx context.Context, crucibleClient *crucible.Crucible, slackClient slack.SlackClient, config StatsConfig, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    cron, err := schedule.Parse(config.Schedule)
+
+    if err != nil {
+        log.Println("Отчёт со статистикой не будет отправляться", err)
+        return
+    }
+
+    location := time.Local
+
+    if config.Timezone != "" {
+        location, err = time.LoadLocation(config.Timezone)
+
+        if err != nil {
+            log.Println("Неизвестная временная зона", config.Timezone, err)
+            location = time.Local
+        }
+    }
+
+    days := config.Days
+    if days <= 0 {
+        days = 7
+    }
+
+    for {
+        next := cron.Next(time.Now().In(location))
+
+        if next.IsZero() {
+            log.Println("Расписание отчёта никогда не срабатывает", cron)
+            return
+        }
+
+        if !sleepContext(ctx, time.Until(next)) {
+            return
+        }
+
+        projects := []string{}
+
+        for projectName := range CONFIG.Get().ProjectMap {
+            projects = append(projects, projectName)
+        }
+
+        sort.Strings(projects)
+
+        if config.Channel != "" {
+            err = postStats(ctx, crucibleClient, &slackClient, config.Channel, projects, days)
+
+            if err != nil {
+                log.Println("Ошибка отправки отчёта", config.Channel, err)
+            }
+
+            continue
+        }
+
+        for _, projectName := range projects {
+            err = postStats(ctx, crucibleClient, &slackClient, projectChannel(projectName), []string{projectName}, days)
+
+            if err != nil {
+                log.Println("Ошибка отправки отчёта", projectName, err)
+            }
+        }
+    }
+}
+
+/**
+    review stats [проект] [период]: статистика ревью, по умолчанию за неделю по проектам канала
+ */
+func commandReviewStats(ctx context.Context, chat Chat, crucibleClient *crucible.Crucible, message SlackMessage) {
+    text := message.Text[strings.Index(message.Text, "review stats")+len("review stats"):]
+
+    days := 7
+    projects := []string{}
+
+    for _, arg := range parseCommandArgs(text) {
+        if period, ok := parseStatsPeriod(arg); ok {
+            days = period
+            continue
+        }
+
+        projects = append(projects, arg)
+    }
+
+    if len(projects) == 0 {
+        for projectName, project := range CONFIG.Get().ProjectMap {
+            if project.Channel == message.ChannelName || chat.ServiceChannel() == message.ChannelName {
+                projects = append(projects, projectName)
+            }
+        }
+
+        sort.Strings(projects)
+    }
+
+    if len(projects) == 0 {
+        chat.PostMessage(ctx, slack.Message{
+            Channel: message.ChannelID,
+            Text: "Не найдено проектов для канала, укажите проект: `review stats <проект> [7d|2w|1m]`",
+        })
+        return
+    }
+
+    err := postStats(ctx, crucibleClient, chat, message.ChannelID, projects, days)
+
+    if err != nil {
+        log.Println("Ошибка получения статистики:", err)
+        chat.PostMessage(ctx, slack.Message{
+            Channel: message.ChannelID,
+            Text: fmt.Sprintf("Не удалось получить статистику: %s", err),
+        })
+    }
+}
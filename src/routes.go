@@ -0,0 +1,250 @@
+package main
+
+import (
+    "./crucible"
+    "./notify"
+    "./slack"
+    "context"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+)
+
+/*
+    Правило маршрутизации уведомлений о ревью. Пустое условие подходит под любое значение.
+    Правила проверяются по порядку, после первого подошедшего проверка останавливается,
+    если у правила не указан `continue`
+ */
+type RouteRule struct {
+    Projects []string `json:"projects"`
+    // Авторы ревью, имена в Crucible
+    Authors []string `json:"authors"`
+    // Хотя бы один из ревьюверов, имена в Crucible
+    Reviewers []string `json:"reviewers"`
+    // Префиксы ключа задачи JIRA, например "PAY-"
+    JiraPrefixes []string `json:"jiraPrefixes"`
+    // Регулярное выражение для названия ревью
+    NameMatch string `json:"nameMatch"`
+    // Типы уведомлений: opened, completed
+    Events []string `json:"events"`
+    States []crucible.State `json:"states"`
+
+    // Каналы Slack
+    Channels []string `json:"channels"`
+    // Личные сообщения: имена в Crucible из userMap — в Slack и Mattermost, остальные — ники в Slack
+    Users []string `json:"users"`
+    // Адреса, на которые уведомление отправляется POST запросом в JSON
+    Webhooks []string `json:"webhooks" secret:"true"`
+    // Файл с адресами webhook по одному в строке, вместо `webhooks`
+    WebhooksFile string `json:"webhooksFile"`
+    // Получатели из `notifiers`: Mattermost, Teams, почта и другие
+    Notifiers []string `json:"notifiers"`
+    // Проверять следующие правила после совпадения
+    Continue bool `json:"continue"`
+}
+
+func matchAny(list []string, value string) bool {
+    return len(list) == 0 || containsString(list, value)
+}
+
+func (rule *RouteRule) Match(projectName string, event string, review crucible.Review) bool {
+    if !matchAny(rule.Projects, projectName) || !matchAny(rule.Events, event) || !matchAny(rule.Authors, review.GetAuthorNick()) {
+        return false
+    }
+
+    if len(rule.States) > 0 {
+        found := false
+
+        for _, state := range rule.States {
+            found = found || state == review.GetState()
+        }
+
+        if !found {
+            return false
+        }
+    }
+
+    if len(rule.Reviewers) > 0 {
+        found := false
+
+        for _, name := range review.GetReviewersNames() {
+            found = found || containsString(rule.Reviewers, name)
+        }
+
+        if !found {
+            return false
+        }
+    }
+
+    if len(rule.JiraPrefixes) > 0 {
+        found := false
+
+        for _, prefix := range rule.JiraPrefixes {
+            found = found || review.JiraIssueKey != "" && strings.HasPrefix(review.JiraIssueKey, prefix)
+        }
+
+        if !found {
+            return false
+        }
+    }
+
+    if rule.NameMatch != "" {
+        matched, err := regexp.MatchString(rule.NameMatch, review.Name)
+
+        if err != nil || !matched {
+            return false
+        }
+    }
+
+    return true
+}
+
+/*
+    Получатели уведомлений по правилу
+ */
+func (rule *RouteRule) Recipients(slackClient slack.SlackClient) (notifiers []notify.Notifier) {
+    for _, channel := range rule.Channels {
+        notifiers = append(notifiers, &notify.Chat{Kind: NotifierSlack, Client: &slackClient, Channel: strings.TrimPrefix(channel, "#")})
+    }
+
+    for _, name := range rule.Users {
+        user, ok := CONFIG.Get().UserMap[name]
+
+        if !ok {
+            notifiers = append(notifiers, &notify.Chat{Kind: NotifierSlack, Client: &slackClient, Channel: "@" + strings.TrimPrefix(name, "@")})
+            continue
+        }
+
+        if user.Slack != "" {
+            notifiers = append(notifiers, &notify.Chat{Kind: NotifierSlack, Client: &slackClient, Channel: "@" + user.Slack})
+        }
+
+        if nick := user.ChatNick(NotifierMattermost); nick != "" && mattermostClient != nil {
+            notifiers = append(notifiers, &notify.Chat{Kind: NotifierMattermost, Client: mattermostClient, Channel: "@" + nick})
+        }
+    }
+
+    for _, webhook := range rule.Webhooks {
+        notifiers = append(notifiers, &notify.Webhook{URL: webhook})
+    }
+
+    for _, name := range rule.Notifiers {
+        config, ok := CONFIG.Get().Notifiers[name]
+
+        if !ok {
+            log.Println("Неизвестный получатель уведомлений", name)
+            continue
+        }
+
+        notifiers = append(notifiers, config.Notifier(slackClient))
+    }
+
+    return
+}
+
+/*
+    Получатели уведомления по правилам `routes`. Если ни одно правило не подошло —
+    каналы проекта, а без них служебный канал, в Slack и, если он настроен, в Mattermost
+ */
+func routeEvent(slackClient slack.SlackClient, projectName string, event string, review crucible.Review) (notifiers []notify.Notifier) {
+    config := CONFIG.Get()
+    matched := false
+    seen := map[string]bool{}
+
+    for _, rule := range config.Routes {
+        if !rule.Match(projectName, event, review) {
+            continue
+        }
+
+        matched = true
+
+        for _, notifier := range rule.Recipients(slackClient) {
+            if !seen[notifier.Name()] {
+                seen[notifier.Name()] = true
+                notifiers = append(notifiers, notifier)
+            }
+        }
+
+        if !rule.Continue {
+            break
+        }
+    }
+
+    if matched {
+        return
+    }
+
+    project := config.Project(projectName)
+
+    for _, channel := range project.AllChannels(projectChannel(projectName)) {
+        notifiers = append(notifiers, &notify.Chat{Kind: NotifierSlack, Client: &slackClient, Channel: channel})
+    }
+
+    if channel := project.GetMattermostChannel(); channel != "" && mattermostClient != nil {
+        notifiers = append(notifiers, &notify.Chat{Kind: NotifierMattermost, Client: mattermostClient, Channel: channel})
+    }
+
+    return
+}
+
+/*
+    Отправляет уведомление всем получателям. Возвращает ответ Slack для первого канала,
+    в его тред пойдут комментарии к ревью
+ */
+func deliver(ctx context.Context, notifiers []notify.Notifier, notification notify.Notification) (first slack.PostMessageResponse, posted bool) {
+    for _, notifier := range notifiers {
+        // Ответ нужен только от первого канала Slack, личные сообщения не в счёт
+        if chat, ok := notifier.(*notify.Chat); ok && chat.Kind == NotifierSlack && !strings.HasPrefix(chat.Channel, "@") {
+            chat.OnPosted = func(response slack.PostMessageResponse) {
+                if !posted {
+                    first, posted = response, true
+                }
+            }
+        }
+
+        if err := notify.Send(ctx, notifier, notification); err != nil {
+            log.Println("Ошибка отправки уведомления", notifier.Name(), err)
+        }
+    }
+
+    return
+}
+
+func validateRoutes(errs *ConfigErrors, routes []RouteRule, notifiers map[string]NotifierConfig) {
+    for i, rule := range routes {
+        path := fmt.Sprintf("routes[%d]", i)
+
+        if rule.NameMatch != "" {
+            if _, err := regexp.Compile(rule.NameMatch); err != nil {
+                errs.Add(path+".nameMatch", "неверное регулярное выражение: %s", err)
+            }
+        }
+
+        for _, event := range rule.Events {
+            if _, ok := defaultTemplates[event]; !ok {
+                errs.Add(path+".events", "неизвестный тип уведомлений %q, возможны opened, completed и removed", event)
+            }
+        }
+
+        for _, state := range rule.States {
+            if !state.IsKnown() {
+                errs.Add(path+".states", "неизвестное состояние %q", state)
+            }
+        }
+
+        for j, webhook := range rule.Webhooks {
+            validateURL(errs, fmt.Sprintf("%s.webhooks[%d]", path, j), webhook)
+        }
+
+        for _, name := range rule.Notifiers {
+            if _, ok := notifiers[name]; !ok {
+                errs.Add(path+".notifiers", "получатель %q не описан в notifiers", name)
+            }
+        }
+
+        if len(rule.Channels) == 0 && len(rule.Users) == 0 && len(rule.Webhooks) == 0 && len(rule.Notifiers) == 0 {
+            errs.Add(path, "не указано, куда отправлять: channels, users, webhooks или notifiers")
+        }
+    }
+}
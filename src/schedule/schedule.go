@@ -0,0 +1,169 @@
+package schedule
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/*
+    Расписание в формате cron из пяти полей: минуты, часы, дни месяца, месяцы, дни недели.
+    Поддерживаются `*`, списки `1,3,5`, диапазоны `1-5` и шаг `*\/15`, `0-30/10`.
+    Дни недели: 0-6 начиная с воскресенья (7 тоже воскресенье).
+ */
+type Schedule struct {
+    minutes map[int]bool
+    hours map[int]bool
+    days map[int]bool
+    months map[int]bool
+    weekdays map[int]bool
+    // Если оба поля дней ограничены, достаточно совпадения одного из них (как в cron)
+    anyDay bool
+    expr string
+}
+
+type field struct {
+    name string
+    min int
+    max int
+}
+
+var fields = []field{
+    {"минуты", 0, 59},
+    {"часы", 0, 23},
+    {"дни месяца", 1, 31},
+    {"месяцы", 1, 12},
+    {"дни недели", 0, 7},
+}
+
+func Parse(expr string) (schedule Schedule, err error) {
+    parts := strings.Fields(expr)
+
+    if len(parts) != len(fields) {
+        err = errors.New(fmt.Sprintf("Расписание `%s`: нужно %d полей, указано %d", expr, len(fields), len(parts)))
+        return
+    }
+
+    sets := make([]map[int]bool, len(fields))
+
+    for i, part := range parts {
+        sets[i], err = parseField(part, fields[i])
+
+        if err != nil {
+            err = errors.New(fmt.Sprintf("Расписание `%s`: %s", expr, err))
+            return
+        }
+    }
+
+    // Воскресенье может быть указано как 7
+    if sets[4][7] {
+        sets[4][0] = true
+        delete(sets[4], 7)
+    }
+
+    schedule = Schedule{
+        minutes: sets[0],
+        hours: sets[1],
+        days: sets[2],
+        months: sets[3],
+        weekdays: sets[4],
+        anyDay: parts[2] != "*" && parts[4] != "*",
+        expr: expr,
+    }
+
+    return
+}
+
+func parseField(value string, f field) (set map[int]bool, err error) {
+    set = map[int]bool{}
+
+    for _, item := range strings.Split(value, ",") {
+        step := 1
+        rangePart := item
+
+        if i := strings.Index(item, "/"); i >= 0 {
+            step, err = strconv.Atoi(item[i+1:])
+
+            if err != nil || step <= 0 {
+                return nil, errors.New(fmt.Sprintf("%s: неверный шаг `%s`", f.name, item))
+            }
+
+            rangePart = item[:i]
+        }
+
+        from, to := f.min, f.max
+
+        if rangePart != "*" {
+            bounds := strings.SplitN(rangePart, "-", 2)
+
+            from, err = strconv.Atoi(bounds[0])
+
+            if err != nil {
+                return nil, errors.New(fmt.Sprintf("%s: неверное значение `%s`", f.name, item))
+            }
+
+            to = from
+
+            if len(bounds) == 2 {
+                to, err = strconv.Atoi(bounds[1])
+
+                if err != nil {
+                    return nil, errors.New(fmt.Sprintf("%s: неверное значение `%s`", f.name, item))
+                }
+            } else if step > 1 {
+                to = f.max
+            }
+        }
+
+        if from < f.min || to > f.max || from > to {
+            return nil, errors.New(fmt.Sprintf("%s: значение `%s` вне диапазона %d-%d", f.name, item, f.min, f.max))
+        }
+
+        for v := from; v <= to; v += step {
+            set[v] = true
+        }
+    }
+
+    return
+}
+
+func (schedule Schedule) String() string {
+    return schedule.expr
+}
+
+/*
+    Совпадает ли минута `t` с расписанием, время сравнивается в зоне `t`
+ */
+func (schedule Schedule) Match(t time.Time) bool {
+    if !schedule.minutes[t.Minute()] || !schedule.hours[t.Hour()] || !schedule.months[int(t.Month())] {
+        return false
+    }
+
+    day, weekday := schedule.days[t.Day()], schedule.weekdays[int(t.Weekday())]
+
+    if schedule.anyDay {
+        return day || weekday
+    }
+
+    return day && weekday
+}
+
+/*
+    Ближайшее время срабатывания строго после `after`, нулевое время если за год не нашлось
+ */
+func (schedule Schedule) Next(after time.Time) time.Time {
+    t := after.Truncate(time.Minute).Add(time.Minute)
+    limit := t.AddDate(1, 0, 0)
+
+    for t.Before(limit) {
+        if schedule.Match(t) {
+            return t
+        }
+
+        t = t.Add(time.Minute)
+    }
+
+    return time.Time{}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "./metrics"
+    "context"
+    "log"
+    "net/http"
+    "sync"
+)
+
+type HTTPConfig struct {
+    // Адрес HTTP сервера для /metrics, /healthz, /readyz, /status и /hooks (с `hooks.serveLog`),
+    // например ":9090", пусто — сервер не запускается
+    Listen string `json:"listen"`
+}
+
+func serveHTTP(ctx context.Context, config HTTPConfig, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", metrics.Handler())
+    mux.HandleFunc("/healthz", healthzHandler)
+    mux.HandleFunc("/readyz", readyzHandler)
+    mux.HandleFunc("/status", statusHandler)
+
+    if CONFIG.Get().Hooks.ServeLog {
+        mux.HandleFunc("/hooks", hooksHandler)
+    }
+
+    if triggers := CONFIG.Get().Triggers; triggers.Enabled() {
+        mux.HandleFunc(triggers.Path, triggerHandler)
+    }
+
+    server := &http.Server{Addr: config.Listen, Handler: mux}
+
+    go func() {
+        <-ctx.Done()
+
+        // Во время остановки бота /metrics и /status ещё доступны
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+        defer cancel()
+
+        server.Shutdown(shutdownCtx)
+    }()
+
+    log.Println("HTTP сервер на", config.Listen)
+
+    err := server.ListenAndServe()
+
+    if err != nil && err != http.ErrServerClosed {
+        log.Println("Ошибка HTTP сервера", err)
+    }
+}
@@ -1,6 +1,8 @@
 package slack
 
 import (
+    "../metrics"
+    "context"
     "net/url"
     "net/http"
     "fmt"
@@ -11,10 +13,18 @@ import (
 )
 
 
+var messagesTotal = metrics.NewCounter(
+    "reviewbot_slack_messages_total",
+    "Сообщения, отправленные в Slack, по результату: sent, failed, rate_limited",
+    "result",
+)
+
 type Config struct  {
     Host string `json:"host"`
-    Token string `json:"token"`
-    Channel string
+    Token string `json:"token" secret:"true"`
+    // Файл с токеном, вместо `token`
+    TokenFile string `json:"tokenFile"`
+    Channel string `json:"channel"`
 }
 
 func (config *Config) ChannelName() string {
@@ -54,6 +64,15 @@ type Message struct {
     Attachments []Attachment
     IconUrl string `json:"icon_url"`
     AsUser bool `json:"as_user"`
+    ThreadTs string `json:"thread_ts"`
+}
+
+// https://api.slack.com/methods/chat.postMessage
+type PostMessageResponse struct {
+    Ok bool `json:"ok"`
+    Error string `json:"error"`
+    Channel string `json:"channel"`
+    Ts string `json:"ts"`
 }
 
 
@@ -85,11 +104,11 @@ func (client *SlackClient) getUrl() url.URL {
 }
 
 
-func (client *SlackClient) TestAuth() (err error) {
+func (client *SlackClient) TestAuth(ctx context.Context) (err error) {
     url := client.getUrl()
     url.Path = "api/auth.test"
 
-    req, err := http.NewRequest("GET", url.String(), nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 
     if err != nil {
         return
@@ -110,7 +129,7 @@ func (client *SlackClient) TestAuth() (err error) {
 }
 
 
-func (client *SlackClient) PostMessage(message Message) (err error) {
+func (client *SlackClient) PostMessage(ctx context.Context, message Message) (posted PostMessageResponse, err error) {
     urlAPI := client.getUrl()
     urlAPI.Path = "/api/chat.postMessage"
 
@@ -127,7 +146,12 @@ func (client *SlackClient) PostMessage(message Message) (err error) {
     form.Add("parse", "full")
     form.Add("link_names", "1")
     form.Add("username", "BotReview")
-    req, err := http.NewRequest("POST", urlAPI.String(), strings.NewReader(form.Encode()))
+
+    if message.ThreadTs != "" {
+        form.Add("thread_ts", message.ThreadTs)
+    }
+
+    req, err := http.NewRequestWithContext(ctx, "POST", urlAPI.String(), strings.NewReader(form.Encode()))
 
     if err != nil {
         return
@@ -135,19 +159,48 @@ func (client *SlackClient) PostMessage(message Message) (err error) {
 
     req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
+    result := "failed"
+    defer func() {
+        messagesTotal.Inc(result)
+    }()
+
     res, err := client.httpClient.Do(req)
 
     if err != nil {
         return
     }
 
+    defer res.Body.Close()
+
     body, err := ioutil.ReadAll(res.Body)
 
+    if res.StatusCode == http.StatusTooManyRequests {
+        result = "rate_limited"
+        err = errors.New(fmt.Sprint("Slack: превышен лимит запросов, повторить через ", res.Header.Get("Retry-After"), " с"))
+        return
+    }
+
     if res.StatusCode > 200 {
         err = errors.New(fmt.Sprint("Slack: не удалось отправить сообщение", string(body[:])))
         return
     }
 
+    err = json.Unmarshal(body, &posted)
+
+    if err != nil {
+        return
+    }
+
+    if !posted.Ok {
+        if posted.Error == "ratelimited" {
+            result = "rate_limited"
+        }
+
+        err = errors.New(fmt.Sprint("Slack: не удалось отправить сообщение ", posted.Error))
+        return
+    }
+
+    result = "sent"
     return
 }
 
@@ -157,6 +210,20 @@ type RTMStart struct {
     Url string `json:"url"`
     Channels []Channel `json:"channels"`
     Groups []Channel `json:"groups"`
+    Users []User `json:"users"`
+}
+
+/*
+    Имя пользователя по id, id приходит в тексте сообщения в упоминаниях вида `<@U024BE7LH>`
+ */
+func (rtmStart *RTMStart) UserName(id string) string {
+    for _, user := range rtmStart.Users {
+        if user.ID == id {
+            return user.Name
+        }
+    }
+
+    return ""
 }
 
 
@@ -176,17 +243,44 @@ func (rtmStart *RTMStart) ChannelName(id string) string {
     return ""
 }
 
+/*
+    Есть ли канал с таким именем, `#` в начале имени не обязателен
+ */
+func (rtmStart *RTMStart) HasChannel(name string) bool {
+    name = strings.TrimPrefix(name, "#")
+
+    for _, channel := range rtmStart.Channels {
+        if channel.Name == name {
+            return true
+        }
+    }
+
+    for _, channel := range rtmStart.Groups {
+        if channel.Name == name {
+            return true
+        }
+    }
+
+    return false
+}
+
 // https://api.slack.com/types/channel
 type Channel struct {
     ID string `json:"id"`
     Name string `json:"name"`
 }
 
-func (client *SlackClient) RTMStart() (rtmStart RTMStart, err error) {
+// https://api.slack.com/types/user
+type User struct {
+    ID string `json:"id"`
+    Name string `json:"name"`
+}
+
+func (client *SlackClient) RTMStart(ctx context.Context) (rtmStart RTMStart, err error) {
     url := client.getUrl()
     url.Path = "api/rtm.start"
 
-    req, err := http.NewRequest("GET", url.String(), nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 
     if err != nil {
         return
@@ -0,0 +1,147 @@
+package stats
+
+import (
+    "../crucible"
+    "sort"
+    "time"
+)
+
+type ReviewerStats struct {
+    UserName string
+    // Сколько ревью завершил за период
+    Completed int
+    // Сколько ревью ждут его сейчас
+    Pending int
+    MedianLatency time.Duration
+    latencies []time.Duration
+}
+
+type Report struct {
+    Project string
+    From time.Time
+    To time.Time
+    // Созданы за период
+    Opened int
+    // Закрыты за период
+    Closed int
+    // Брошены (Dead, Rejected) за период
+    Abandoned int
+    // Медиана времени от создания до завершения нужным числом ревьюверов
+    MedianCompletion time.Duration
+    Reviewers []*ReviewerStats
+}
+
+func inPeriod(t time.Time, from time.Time, to time.Time) bool {
+    return !t.IsZero() && !t.Before(from) && t.Before(to)
+}
+
+func Median(values []time.Duration) time.Duration {
+    if len(values) == 0 {
+        return 0
+    }
+
+    sorted := append([]time.Duration{}, values...)
+    sort.Slice(sorted, func(i, j int) bool {
+        return sorted[i] < sorted[j]
+    })
+
+    middle := len(sorted) / 2
+
+    if len(sorted)%2 == 0 {
+        return (sorted[middle-1] + sorted[middle]) / 2
+    }
+
+    return sorted[middle]
+}
+
+/*
+    Статистика ревью проекта за период [from, to). Ревью завершено, когда его завершили
+    `completedReviewers` ревьюверов, см. Review.IsCompletedBy
+ */
+func Compute(project string, completedReviewers int, reviews []crucible.Review, from time.Time, to time.Time) (report Report) {
+    report = Report{Project: project, From: from, To: to}
+    reviewers := map[string]*ReviewerStats{}
+    completions := []time.Duration{}
+
+    reviewerStats := func(userName string) *ReviewerStats {
+        stats, ok := reviewers[userName]
+
+        if !ok {
+            stats = &ReviewerStats{UserName: userName}
+            reviewers[userName] = stats
+        }
+
+        return stats
+    }
+
+    for _, review := range reviews {
+        if project != "" && review.ProjectKey != project {
+            continue
+        }
+
+        if inPeriod(review.CreateDate.Time, from, to) {
+            report.Opened++
+        }
+
+        // Если дата закрытия неизвестна, считаем по дате создания
+        closeDate := review.CloseDate.Time
+        if closeDate.IsZero() {
+            closeDate = review.CreateDate.Time
+        }
+
+        switch review.GetState() {
+        case crucible.StateClosed:
+            if inPeriod(closeDate, from, to) {
+                report.Closed++
+            }
+        case crucible.StateDead, crucible.StateRejected:
+            if inPeriod(closeDate, from, to) {
+                report.Abandoned++
+            }
+        }
+
+        if completion, ok := review.CompletionTime(completedReviewers); ok && inPeriod(completion, from, to) && !review.CreateDate.IsZero() {
+            completions = append(completions, completion.Sub(review.CreateDate.Time))
+        }
+
+        for _, reviewer := range review.Reviewers.Reviewer {
+            if !reviewer.Completed {
+                if review.GetState().IsActive() {
+                    reviewerStats(reviewer.UserName).Pending++
+                }
+                continue
+            }
+
+            if !inPeriod(reviewer.CompletionStatusChangeDate.Time, from, to) {
+                continue
+            }
+
+            stats := reviewerStats(reviewer.UserName)
+            stats.Completed++
+
+            if latency, ok := review.ReviewerLatency(reviewer.UserName); ok {
+                stats.latencies = append(stats.latencies, latency)
+            }
+        }
+    }
+
+    report.MedianCompletion = Median(completions)
+
+    for _, stats := range reviewers {
+        stats.MedianLatency = Median(stats.latencies)
+        report.Reviewers = append(report.Reviewers, stats)
+    }
+
+    // Больше всего завершённых — выше
+    sort.Slice(report.Reviewers, func(i, j int) bool {
+        a, b := report.Reviewers[i], report.Reviewers[j]
+
+        if a.Completed != b.Completed {
+            return a.Completed > b.Completed
+        }
+
+        return a.UserName < b.UserName
+    })
+
+    return
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+    "./crucible"
+    "./metrics"
+    "context"
+    "crypto/subtle"
+    "encoding/json"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+var triggersTotal = metrics.NewCounter(
+    "reviewbot_crucible_triggers_total",
+    "Вызовы из Crucible по результату: accepted, rejected, dropped, failed",
+    "result",
+)
+
+/*
+    Приём вызовов из Crucible (webhook, trigger) о событиях ревью. Бот сам получает ревью
+    по id из вызова, опрос проектов остаётся для сверки, но реже
+ */
+type TriggerConfig struct {
+    // Путь на HTTP сервере `http.listen`, например "/crucible", пусто — приёмник выключен
+    Path string `json:"path"`
+    // Токен в заголовке X-Reviewbot-Token или, если Crucible не умеет заголовки, в параметре `token`
+    Token string `json:"token" secret:"true"`
+    // Файл с токеном, вместо `token`
+    TokenFile string `json:"tokenFile"`
+    // Интервал опроса проектов в секундах, пока приходят вызовы, по умолчанию в 10 раз реже обычного
+    PollInterval time.Duration `json:"pollInterval"`
+}
+
+func (config *TriggerConfig) Enabled() bool {
+    return config.Path != ""
+}
+
+// Если вызовов не было столько времени, проекты опрашиваются как обычно
+const triggerSilence = time.Hour
+
+// id ревью из вызовов, разбирается в watchTriggers
+var triggerQueue = make(chan string, 100)
+
+var lastTrigger struct {
+    mutex sync.Mutex
+    time time.Time
+}
+
+func triggerReceived() {
+    lastTrigger.mutex.Lock()
+    defer lastTrigger.mutex.Unlock()
+
+    lastTrigger.time = time.Now()
+}
+
+/*
+    Интервал опроса проекта: обычный, а пока приходят вызовы из Crucible — сверка раз в `triggers.pollInterval`
+ */
+func projectPollInterval(projectName string) time.Duration {
+    config := CONFIG.Get()
+    interval := config.Project(projectName).PollInterval * time.Second
+
+    if !config.Triggers.Enabled() {
+        return interval
+    }
+
+    lastTrigger.mutex.Lock()
+    silence := time.Since(lastTrigger.time)
+    lastTrigger.mutex.Unlock()
+
+    if silence > triggerSilence {
+        return interval
+    }
+
+    if config.Triggers.PollInterval > 0 {
+        return config.Triggers.PollInterval * time.Second
+    }
+
+    return 10 * interval
+}
+
+/*
+    id ревью из вызова: параметр `review` или `reviewId`, либо JSON с `reviewId`, `permaId.id`
+    или `review` (строкой или объектом с `permaId.id`)
+ */
+func triggerReviewID(request *http.Request, body []byte) string {
+    for _, name := range []string{"review", "reviewId"} {
+        if id := request.URL.Query().Get(name); id != "" {
+            return id
+        }
+    }
+
+    var data map[string]interface{}
+
+    if json.Unmarshal(body, &data) != nil {
+        return ""
+    }
+
+    return findReviewID(data)
+}
+
+func findReviewID(data map[string]interface{}) string {
+    if id, ok := data["reviewId"].(string); ok {
+        return id
+    }
+
+    if permaID, ok := data["permaId"].(map[string]interface{}); ok {
+        if id, ok := permaID["id"].(string); ok {
+            return id
+        }
+    }
+
+    switch review := data["review"].(type) {
+    case string:
+        return review
+    case map[string]interface{}:
+        return findReviewID(review)
+    }
+
+    return ""
+}
+
+func triggerHandler(writer http.ResponseWriter, request *http.Request) {
+    if request.Method != "POST" {
+        http.Error(writer, "нужен POST", http.StatusMethodNotAllowed)
+        return
+    }
+
+    config := CONFIG.Get().Triggers
+
+    // Параметр из адреса попадает в журналы доступа, заголовок предпочтительнее
+    token := request.Header.Get("X-Reviewbot-Token")
+    if token == "" {
+        token = request.URL.Query().Get("token")
+    }
+
+    if config.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) != 1 {
+        triggersTotal.Inc("rejected")
+        http.Error(writer, "неверный токен", http.StatusUnauthorized)
+        return
+    }
+
+    body, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, 1 << 20))
+
+    if err != nil {
+        triggersTotal.Inc("rejected")
+        http.Error(writer, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    reviewID := strings.TrimSpace(triggerReviewID(request, body))
+
+    if reviewID == "" {
+        triggersTotal.Inc("rejected")
+        http.Error(writer, "не найден id ревью", http.StatusBadRequest)
+        return
+    }
+
+    select {
+    case triggerQueue <- reviewID:
+        triggerReceived()
+        triggersTotal.Inc("accepted")
+        writer.WriteHeader(http.StatusAccepted)
+    default:
+        // Ревью всё равно попадёт в опрос проекта
+        triggersTotal.Inc("dropped")
+        http.Error(writer, "очередь переполнена", http.StatusServiceUnavailable)
+    }
+}
+
+/*
+    Получает ревью из вызовов Crucible и передаёт их опросу проекта, чтобы события
+    шли тем же путём, что и при опросе
+ */
+func watchTriggers(ctx context.Context, crucibleClient *crucible.Crucible, supervisor *ProjectSupervisor, wg *sync.WaitGroup) {
+    defer wg.Done()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case reviewID := <-triggerQueue:
+            review, err := crucibleClient.GetReview(ctx, reviewID)
+
+            if ctx.Err() != nil {
+                return
+            }
+
+            if err != nil {
+                triggersTotal.Inc("failed")
+                log.Println("Ошибка получения ревью по вызову из Crucible", reviewID, err)
+                continue
+            }
+
+            if !supervisor.Push(review.ProjectKey, review) {
+                log.Println("Ревью из вызова Crucible не из отслеживаемого проекта", reviewID, review.ProjectKey)
+            }
+        }
+    }
+}
+
+func validateTriggers(errs *ConfigErrors, config Config) {
+    triggers := config.Triggers
+
+    if triggers.PollInterval < 0 {
+        errs.Add("triggers.pollInterval", "не может быть отрицательным")
+    }
+
+    if !triggers.Enabled() {
+        return
+    }
+
+    if triggers.Token == "" {
+        errs.Add("triggers.token", "обязательное поле, либо укажите triggers.tokenFile")
+    }
+
+    if !strings.HasPrefix(triggers.Path, "/") {
+        errs.Add("triggers.path", "путь должен начинаться с /")
+    }
+
+    for _, path := range []string{"/metrics", "/healthz", "/readyz", "/status", "/hooks"} {
+        if triggers.Path == path {
+            errs.Add("triggers.path", "путь %s уже занят", path)
+        }
+    }
+
+    if config.HTTP.Listen == "" {
+        errs.Add("http.listen", "нужен HTTP сервер для приёма вызовов из Crucible")
+    }
+}
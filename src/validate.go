@@ -0,0 +1,374 @@
+package main
+
+import (
+    "./schedule"
+    "./slack"
+    "context"
+    "errors"
+    "flag"
+    "fmt"
+    "net/url"
+    "reflect"
+    "sort"
+    "strings"
+    "time"
+)
+
+/*
+    Все ошибки проверки конфига, каждая с путём к полю
+ */
+type ConfigErrors []string
+
+func (errs ConfigErrors) Error() string {
+    return "\n    " + strings.Join(errs, "\n    ")
+}
+
+func (errs *ConfigErrors) Add(path string, format string, args ...interface{}) {
+    *errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+}
+
+/*
+    Ключи конфига, которым нет соответствующего поля
+ */
+func unknownConfigKeys(raw interface{}, t reflect.Type, path string) (errs ConfigErrors) {
+    switch t.Kind() {
+    case reflect.Struct:
+        object, ok := raw.(map[string]interface{})
+
+        if !ok {
+            return
+        }
+
+        fields := map[string]reflect.Type{}
+
+        for i := 0; i < t.NumField(); i++ {
+            if t.Field(i).PkgPath == "" {
+                fields[configFieldName(t.Field(i))] = t.Field(i).Type
+            }
+        }
+
+        keys := []string{}
+
+        for key := range object {
+            keys = append(keys, key)
+        }
+
+        sort.Strings(keys)
+
+        for _, key := range keys {
+            fieldType, ok := fields[key]
+
+            if !ok {
+                errs.Add(joinConfigPath(path, key), "неизвестный ключ")
+                continue
+            }
+
+            errs = append(errs, unknownConfigKeys(object[key], fieldType, joinConfigPath(path, key))...)
+        }
+    case reflect.Map:
+        object, ok := raw.(map[string]interface{})
+
+        if !ok {
+            return
+        }
+
+        for key, value := range object {
+            errs = append(errs, unknownConfigKeys(value, t.Elem(), joinConfigPath(path, key))...)
+        }
+
+        sort.Strings(errs)
+    case reflect.Slice:
+        list, ok := raw.([]interface{})
+
+        if !ok {
+            return
+        }
+
+        for i, item := range list {
+            errs = append(errs, unknownConfigKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+        }
+    }
+
+    return
+}
+
+func joinConfigPath(path string, key string) string {
+    if path == "" {
+        return key
+    }
+
+    return path + "." + key
+}
+
+func validateURL(errs *ConfigErrors, path string, value string) {
+    if value == "" {
+        errs.Add(path, "обязательное поле")
+        return
+    }
+
+    parsed, err := url.Parse(value)
+
+    if err != nil {
+        errs.Add(path, "неверный адрес: %s", err)
+        return
+    }
+
+    if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+        errs.Add(path, "нужен адрес вида https://host, указано %q", value)
+    }
+}
+
+func validateRequired(errs *ConfigErrors, path string, value string) {
+    if value == "" {
+        errs.Add(path, "обязательное поле")
+    }
+}
+
+func validateTimezone(errs *ConfigErrors, path string, value string) {
+    if value == "" {
+        return
+    }
+
+    if _, err := time.LoadLocation(value); err != nil {
+        errs.Add(path, "неизвестная временная зона %q", value)
+    }
+}
+
+func validateSchedule(errs *ConfigErrors, path string, value string) {
+    if _, err := schedule.Parse(value); err != nil {
+        errs.Add(path, "неверное расписание %q: %s", value, err)
+    }
+}
+
+/*
+    Раздел crucible, его одного достаточно для export
+ */
+func validateCrucible(config Config) (errs ConfigErrors) {
+    validateURL(&errs, "crucible.host", config.Crucible.Host)
+    validateRequired(&errs, "crucible.login", config.Crucible.Login)
+
+    if config.Crucible.Password == "" {
+        errs.Add("crucible.password", "обязательное поле, либо укажите crucible.passwordFile")
+    }
+
+    if config.Crucible.Timeout <= 0 {
+        errs.Add("crucible.timeout", "интервал опроса в секундах должен быть больше нуля")
+    }
+
+    return
+}
+
+/*
+    Проверка значений конфига без обращения к Crucible и Slack
+ */
+func validateConfig(config Config) (errs ConfigErrors) {
+    errs = validateCrucible(config)
+
+    validateURL(&errs, "slack.host", config.Slack.Host)
+
+    if config.Slack.Token == "" {
+        errs.Add("slack.token", "обязательное поле, либо укажите slack.tokenFile")
+    }
+
+    validateRequired(&errs, "slack.channel", config.Slack.Channel)
+
+    if config.Mattermost.Enabled() {
+        validateURL(&errs, "mattermost.host", config.Mattermost.Host)
+
+        if config.Mattermost.Token == "" {
+            errs.Add("mattermost.token", "обязательное поле, либо укажите mattermost.tokenFile")
+        }
+
+        validateRequired(&errs, "mattermost.team", config.Mattermost.Team)
+    }
+
+    if len(config.ProjectMap) == 0 {
+        errs.Add("projectMap", "надо указать хотя бы один проект")
+    }
+
+    for projectName, project := range config.ProjectMap {
+        validateProject(&errs, projectName, project)
+
+        if project.MattermostChannel != "" && !config.Mattermost.Enabled() {
+            errs.Add("projectMap."+projectName+".mattermostChannel", "нужны настройки mattermost")
+        }
+    }
+
+    reminders := config.Reminders
+
+    if reminders.After < 0 {
+        errs.Add("reminders.after", "не может быть отрицательным")
+    }
+
+    if reminders.Enabled() {
+        if reminders.EscalateAfter < 0 {
+            errs.Add("reminders.escalateAfter", "не может быть отрицательным")
+        } else if reminders.EscalateAfter > 0 && reminders.EscalateAfter < reminders.After {
+            errs.Add("reminders.escalateAfter", "должно быть не меньше reminders.after")
+        }
+
+        if reminders.Repeat < 0 {
+            errs.Add("reminders.repeat", "не может быть отрицательным")
+        }
+
+        if reminders.Interval < 0 {
+            errs.Add("reminders.interval", "не может быть отрицательным")
+        }
+
+        if reminders.QuietFrom < 0 || reminders.QuietFrom > 23 {
+            errs.Add("reminders.quietFrom", "час от 0 до 23")
+        }
+
+        if reminders.QuietTo < 0 || reminders.QuietTo > 23 {
+            errs.Add("reminders.quietTo", "час от 0 до 23")
+        }
+
+        validateTimezone(&errs, "reminders.timezone", reminders.Timezone)
+    }
+
+    for i, digest := range config.Digests {
+        path := fmt.Sprintf("digests[%d]", i)
+
+        validateRequired(&errs, path+".channel", digest.Channel)
+        validateSchedule(&errs, path+".schedule", digest.Schedule)
+        validateTimezone(&errs, path+".timezone", digest.Timezone)
+
+        for _, projectName := range digest.Projects {
+            if _, ok := config.ProjectMap[projectName]; !ok {
+                errs.Add(path+".projects", "проекта %q нет в projectMap", projectName)
+            }
+        }
+    }
+
+    validateRoutes(&errs, config.Routes, config.Notifiers)
+    validateNotifiers(&errs, config)
+    validateMailDigest(&errs, config)
+    validateHooks(&errs, config.Hooks, config.ProjectMap)
+    validateTriggers(&errs, config)
+    validatePolling(&errs, config.Polling)
+
+    if config.History.Days < 0 {
+        errs.Add("history.days", "не может быть отрицательным")
+    }
+
+    if config.Stats.Schedule != "" {
+        validateSchedule(&errs, "stats.schedule", config.Stats.Schedule)
+        validateTimezone(&errs, "stats.timezone", config.Stats.Timezone)
+    }
+
+    if config.Stats.Days < 0 {
+        errs.Add("stats.days", "не может быть отрицательным")
+    }
+
+    sort.Strings(errs)
+    return
+}
+
+/*
+    Проверка, что каналы из конфига существуют в Slack
+ */
+func validateSlackChannels(config Config, rtmStart slack.RTMStart) (errs ConfigErrors) {
+    check := func(path string, channel string) {
+        if channel != "" && !rtmStart.HasChannel(channel) {
+            errs.Add(path, "канал %q не найден в Slack", channel)
+        }
+    }
+
+    check("slack.channel", config.Slack.Channel)
+
+    for projectName, project := range config.ProjectMap {
+        check("projectMap."+projectName+".channel", project.Channel)
+
+        for i, channel := range project.Channels {
+            check(fmt.Sprintf("projectMap.%s.channels[%d]", projectName, i), channel)
+        }
+
+        if strings.HasPrefix(project.Reminders.Lead, "#") {
+            check("projectMap."+projectName+".reminders.lead", project.Reminders.Lead)
+        }
+    }
+
+    for i, digest := range config.Digests {
+        check(fmt.Sprintf("digests[%d].channel", i), digest.Channel)
+    }
+
+    check("stats.channel", config.Stats.Channel)
+
+    for name, notifier := range config.Notifiers {
+        if notifier.Type == NotifierSlack {
+            check("notifiers."+name+".channel", notifier.Channel)
+        }
+    }
+
+    for i, rule := range config.Routes {
+        for j, channel := range rule.Channels {
+            check(fmt.Sprintf("routes[%d].channels[%d]", i, j), channel)
+        }
+    }
+
+    // Эскалация может быть и на пользователя, каналы начинаются с #
+    if strings.HasPrefix(config.Reminders.EscalateTo, "#") {
+        check("reminders.escalateTo", config.Reminders.EscalateTo)
+    }
+
+    for projectName, lead := range config.Reminders.Leads {
+        if strings.HasPrefix(lead, "#") {
+            check("reminders.leads."+projectName, lead)
+        }
+    }
+
+    sort.Strings(errs)
+    return
+}
+
+/*
+    review_bot validate — проверка конфига, в том числе каналов в Slack
+ */
+func runValidate(args []string) (err error) {
+    flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+    offline := flags.Bool("offline", false, "не проверять каналы в Slack")
+
+    err = flags.Parse(args)
+
+    if err == flag.ErrHelp {
+        return nil
+    }
+
+    if err != nil {
+        return
+    }
+
+    config, err := getConfig(configPath)
+
+    if err != nil {
+        return
+    }
+
+    if *offline {
+        fmt.Println("Конфиг в порядке:", configPath)
+        return
+    }
+
+    slackClient, err := slack.CreateClient(config.Slack)
+
+    if err != nil {
+        return
+    }
+
+    rtmStart, err := slackClient.RTMStart(context.Background())
+
+    if err != nil {
+        return
+    }
+
+    if !rtmStart.Ok {
+        return errors.New("Slack: не удалось получить список каналов")
+    }
+
+    if errs := validateSlackChannels(config, rtmStart); len(errs) > 0 {
+        return errs
+    }
+
+    fmt.Println("Конфиг в порядке:", configPath)
+    return
+}